@@ -16,8 +16,14 @@ go mod tidy
 :: Create build directory
 if not exist build mkdir build
 
-echo Building APIGO...
-go build -o build/m.exe src/m.go
+:: Version and commit are embedded for /version
+set COMMIT=unknown
+for /f %%i in ('git rev-parse --short HEAD 2^>nul') do set COMMIT=%%i
+for /f %%i in ('git describe --tags --always 2^>nul') do set VERSION=%%i
+if not defined VERSION set VERSION=dev
+
+echo Building APIGO %VERSION% (%COMMIT%)...
+go build -ldflags "-X main.version=%VERSION% -X main.commit=%COMMIT%" -o build/m.exe ./src
 
 :: Check build result
 if %errorlevel% neq 0 (
@@ -37,6 +37,8 @@ nssm.exe set APIGO AppStdout "%CURRENT_DIR%\build\stdout.log"
 nssm.exe set APIGO AppStderr "%CURRENT_DIR%\build\stderr.log"
 nssm.exe set APIGO AppRotateFiles 1
 nssm.exe set APIGO AppRotateBytes 10485760
+:: 停止服务时先发送Ctrl+C并等待35秒，留给进行中的请求完成（server.drain默认30秒）
+nssm.exe set APIGO AppStopMethodConsole 35000
 nssm.exe set APIGO Start SERVICE_AUTO_START
 
 :: 启动服务
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
@@ -35,6 +36,10 @@ type (
 		Query  string `json:"query"`  // 用于获取SQL模板的查询语句
 		Api    string `json:"api"`    // API路由路径
 		Port   int    `json:"port"`   // 服务监听端口
+		Log    struct {
+			Level  string   `json:"level"`  // 日志级别：debug/info/warn/error，默认info；debug时记录脱敏后的请求参数
+			Redact []string `json:"redact"` // 脱敏的字段名，不区分大小写，默认 password/token/code/secret
+		} `json:"log"`
 	}
 )
 
@@ -42,6 +47,8 @@ var (
 	db      *sqlx.DB   // 数据库连接实例
 	cfg     = new(Cfg) // 配置实例
 	dbMutex sync.Mutex // 保护数据库连接操作的互斥锁
+
+	redactKeys = make(map[string]bool) // 日志中脱敏的参数名（小写），来自 log.redact
 )
 
 // main 程序入口函数
@@ -52,6 +59,19 @@ func main() {
 	CatchErr("READ-CONF:", err)
 	CatchErr("PARSE-CONF:", json.Unmarshal(b, &cfg))
 
+	// 结构化JSON日志
+	if cfg.Log.Redact == nil {
+		cfg.Log.Redact = []string{"password", "token", "code", "secret"}
+	}
+	for _, k := range cfg.Log.Redact {
+		redactKeys[strings.ToLower(k)] = true
+	}
+	var level slog.Level
+	if cfg.Log.Level != "" {
+		CatchErr("LOG-LEVEL:", level.UnmarshalText([]byte(cfg.Log.Level)))
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+
 	// 初始化数据库连接池
 	initDB()
 
@@ -120,12 +140,12 @@ func reconnectDB() {
 func Api(c *gin.Context) {
 	// 解析请求参数
 	param := ParseForm(c)
-	log.Println("REQ:", param)
 
 	// 获取路由参数和HTTP方法
 	action := c.Param("a")     // 从路由路径中提取动作参数
 	method := c.Request.Method // 获取HTTP方法(GET/POST等)
 	sqlstr := cfg.Query        // 获取SQL模板查询语句
+	slog.Debug("REQ", "route", action, "method", method, "params", redact(param))
 
 	// 从数据库获取SQL模板
 	tmpStr := ""
@@ -144,7 +164,7 @@ func Api(c *gin.Context) {
 	if e = tmp.Execute(buf, param); e == nil {
 		tmpsql = buf.String()
 	}
-	log.Println("TMP:", tmpsql, e)
+	slog.Debug("TMP", "sql", tmpsql, "error", e)
 
 	// 执行SQL查询
 	data := make([]Map, 0)
@@ -162,7 +182,7 @@ func Api(c *gin.Context) {
 			data = append(data, mp)
 		}
 	}
-	log.Println("RET:", data)
+	slog.Info("RET", "route", action, "method", method, "rows", len(data))
 	// 返回JSON格式的结果
 	c.JSON(http.StatusOK, Map{"data": data, "status": 0})
 }
@@ -208,9 +228,39 @@ func ParseForm(c *gin.Context) Map {
 	return param
 }
 
+// redact 复制参数并将脱敏字段的值替换为 ***，嵌套对象与数组递归处理
+func redact(v any) any {
+	switch v := v.(type) {
+	case Map:
+		return redactMap(v)
+	case map[string]any:
+		return redactMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = redact(e)
+		}
+		return out
+	}
+	return v
+}
+
+// redactMap 见 redact
+func redactMap(m map[string]any) Map {
+	out := make(Map, len(m))
+	for k, v := range m {
+		if redactKeys[strings.ToLower(k)] {
+			out[k] = "***"
+		} else {
+			out[k] = redact(v)
+		}
+	}
+	return out
+}
+
 // CatchErr 简单的错误处理函数，记录错误但不中断执行
 func CatchErr(desc string, err error) {
 	if err != nil {
-		log.Println(desc, err)
+		slog.Error(desc, "error", err)
 	}
 }
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin" // Web框架
+	"github.com/jmoiron/sqlx"  // 增强的数据库操作包
+)
+
+// Admin 定义路由管理接口，路由表的结构以 cfg.Query 所查询的表为准
+type Admin struct {
+	Users    []string `json:"users"`    // 允许使用管理接口的用户名，为空时关闭管理接口
+	Table    string   `json:"table"`    // 路由表名，默认从 query 的 FROM 子句解析
+	ID       string   `json:"id"`       // 创建路由时自动生成随机值的主键列，如 RecordID，为空时由数据库生成
+	Versions string   `json:"versions"` // 版本表名，为空时不记录版本历史
+}
+
+// fromTable 从 cfg.Query 中解析路由表名
+var fromTable = regexp.MustCompile("(?i)\\bFROM\\s+([\\p{L}\\p{N}_.\\[\\]\"`]+)")
+
+// AdminAuth 管理接口鉴权：必须携带有效令牌，且用户名在 admin.users 中
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := callerClaims(c)
+		if claims == nil {
+			Fail(c, errUnauthorized)
+			return
+		}
+		if !isAdmin(claims) {
+			Fail(c, errForbidden)
+			return
+		}
+		c.Set("userID", claims.UserID)
+		c.Set("userName", claims.UserName)
+		c.Next()
+	}
+}
+
+// isAdmin 判断令牌中的用户名是否在 admin.users 中
+func isAdmin(claims *Claims) bool {
+	if claims == nil {
+		return false
+	}
+	for _, u := range cfg.Admin.Users {
+		if u == claims.UserName {
+			return true
+		}
+	}
+	return false
+}
+
+// adminRoutes 注册路由管理接口
+func adminRoutes(g *gin.RouterGroup) {
+	g.Use(AdminAuth())
+	g.GET("/routes", AdminList)
+	g.POST("/routes", AdminSave)
+	g.GET("/routes/:method/:name", AdminGet)
+	g.PUT("/routes/:method/:name", AdminSave)
+	g.DELETE("/routes/:method/:name", AdminDelete)
+	g.POST("/routes/:method/:name/enable", AdminEnable(1))
+	g.POST("/routes/:method/:name/disable", AdminEnable(0))
+	g.GET("/routes/:method/:name/versions", AdminVersions)
+	g.POST("/routes/:method/:name/rollback/:version", AdminRollback)
+	g.POST("/reload", AdminReload)
+}
+
+// AdminList 列出路由表中的全部路由
+func AdminList(c *gin.Context) {
+	db, q, err := adminDB()
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	res, err := queryRows(c.Request.Context(), db, true, "SELECT * FROM "+q.table+" ORDER BY "+q.ident("路由")+", "+q.ident("方法"))
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, Map{"data": res.Rows, "status": 0})
+}
+
+// AdminGet 获取单个路由
+func AdminGet(c *gin.Context) {
+	db, q, err := adminDB()
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	row, err := q.get(c.Request.Context(), db, c.Param("name"), c.Param("method"))
+	if errors.Is(err, sql.ErrNoRows) {
+		Fail(c, errNotFound.with("路由不存在", nil))
+		return
+	}
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, Map{"data": row, "status": 0})
+}
+
+// AdminSave 创建(POST)或更新(PUT)路由，请求体的键为路由表列名
+// 保存前解析模板、默认值与结构；?dryrun=1 时以 _params 为参数在回滚的事务中试运行
+func AdminSave(c *gin.Context) {
+	ctx := c.Request.Context()
+	var body Map
+	if err := c.ShouldBindJSON(&body); err != nil {
+		Fail(c, errBadRequest.with("请求体必须是JSON对象", err))
+		return
+	}
+	params, _ := body["_params"].(map[string]any)
+	delete(body, "_params")
+
+	db, q, err := adminDB()
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	cols, err := q.columns(ctx, db)
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	var unknown []string
+	for _, k := range sortedKeys(body) {
+		if !cols[k] {
+			unknown = append(unknown, k)
+		}
+		// 对象或数组类型的值（默认值、结构、参数）保存为JSON文本
+		switch v := body[k].(type) {
+		case map[string]any, []any:
+			b, _ := json.Marshal(v)
+			body[k] = string(b)
+		}
+	}
+	if len(unknown) > 0 {
+		Fail(c, errBadRequest.with("路由表中不存在列: "+strings.Join(unknown, ", "), Map{"unknown": unknown}))
+		return
+	}
+	if m, ok := body["方法"].(string); ok {
+		body["方法"] = strings.ToUpper(m)
+	}
+
+	// 更新时与现有定义合并后校验
+	var old Map
+	merged, update := Map{}, c.Request.Method == http.MethodPut
+	if update {
+		if old, err = q.get(ctx, db, c.Param("name"), c.Param("method")); errors.Is(err, sql.ErrNoRows) {
+			Fail(c, errNotFound.with("路由不存在", nil))
+			return
+		} else if err != nil {
+			Fail(c, dbErr(err))
+			return
+		}
+		for k, v := range old {
+			merged[k] = v
+		}
+	}
+	for k, v := range body {
+		merged[k] = v
+	}
+	name, method := rowText(merged, "路由"), rowText(merged, "方法")
+	if name == "" || method == "" || rowText(merged, "模板") == "" {
+		Fail(c, errBadRequest.with("路由、方法、模板不能为空", nil))
+		return
+	}
+	route, ae := validateRoute(merged, method+" "+name)
+	if ae != nil {
+		Fail(c, ae)
+		return
+	}
+	var dry Map
+	if c.Query("dryrun") == "1" {
+		if dry, err = dryRun(ctx, route, params); err != nil {
+			Fail(c, errBadRequest.with("试运行失败", err))
+			return
+		}
+	}
+
+	row, err := q.commit(c, db, name, method, old, "", func(tx *sqlx.Tx) (int64, error) {
+		if update {
+			sqlstr, args := q.update(body)
+			return q.exec(ctx, tx, sqlstr, append(args, c.Param("name"), strings.ToUpper(c.Param("method")))...)
+		}
+		if id := cfg.Admin.ID; id != "" && body[id] == nil {
+			body[id] = randomHex(16)
+		}
+		sqlstr, args := q.insert(body)
+		return q.exec(ctx, tx, sqlstr, args...)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		Fail(c, errNotFound.with("路由不存在", nil))
+		return
+	}
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	resp := Map{"data": row, "status": 0}
+	if dry != nil {
+		resp["dryrun"] = dry
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// AdminDelete 删除路由，删除前的定义记录为新版本，可回滚恢复
+func AdminDelete(c *gin.Context) {
+	ctx := c.Request.Context()
+	name, method := c.Param("name"), strings.ToUpper(c.Param("method"))
+	db, q, err := adminDB()
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	old, err := q.get(ctx, db, name, method)
+	if errors.Is(err, sql.ErrNoRows) {
+		Fail(c, errNotFound.with("路由不存在", nil))
+		return
+	}
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	_, err = q.commit(c, db, name, method, old, "删除", func(tx *sqlx.Tx) (int64, error) {
+		sqlstr := "DELETE FROM " + q.table + " WHERE " + q.ident("路由") + " = ? AND " + q.ident("方法") + " = ?"
+		return q.exec(ctx, tx, sqlstr, name, method)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		Fail(c, errNotFound.with("路由不存在", nil))
+		return
+	}
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, Map{"affected": 1, "status": 0})
+}
+
+// AdminEnable 启用(1)或停用(0)路由，路由表须有 启用 列，且 cfg.Query 须查询该列，否则停用不生效
+func AdminEnable(on int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		db, q, err := adminDB()
+		if err != nil {
+			Fail(c, dbErr(err))
+			return
+		}
+		cols, err := q.columns(ctx, db)
+		if err != nil {
+			Fail(c, dbErr(err))
+			return
+		}
+		if !cols["启用"] {
+			Fail(c, errBadRequest.with("路由表没有启用列", nil))
+			return
+		}
+		if cols, err = queryColumns(ctx, db); err != nil {
+			Fail(c, dbErr(err))
+			return
+		}
+		if !cols["启用"] {
+			Fail(c, errBadRequest.with("路由查询query未查询启用列", nil))
+			return
+		}
+		name, method := c.Param("name"), strings.ToUpper(c.Param("method"))
+		old, err := q.get(ctx, db, name, method)
+		if errors.Is(err, sql.ErrNoRows) {
+			Fail(c, errNotFound.with("路由不存在", nil))
+			return
+		}
+		if err != nil {
+			Fail(c, dbErr(err))
+			return
+		}
+		_, err = q.commit(c, db, name, method, old, "", func(tx *sqlx.Tx) (int64, error) {
+			sqlstr, args := q.update(Map{"启用": on})
+			return q.exec(ctx, tx, sqlstr, append(args, name, method)...)
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			Fail(c, errNotFound.with("路由不存在", nil))
+			return
+		}
+		if err != nil {
+			Fail(c, dbErr(err))
+			return
+		}
+		c.JSON(http.StatusOK, Map{"affected": 1, "status": 0})
+	}
+}
+
+// queryColumns 返回 cfg.Query 查询结果的列名
+func queryColumns(ctx context.Context, db *sqlx.DB) (map[string]bool, error) {
+	rows, err := db.QueryxContext(ctx, db.Rebind(cfg.Query), "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	cols := make(map[string]bool, len(names))
+	for _, n := range names {
+		cols[n] = true
+	}
+	return cols, err
+}
+
+// AdminReload 立即重建路由注册表，failed 列出编译失败的路由及原因
+func AdminReload(c *gin.Context) {
+	if err := reloadRegistry(); err != nil {
+		Fail(c, errTemplate.with("路由注册表构建失败", err))
+		return
+	}
+	c.JSON(http.StatusOK, Map{"status": 0, "failed": registry.Load().failed})
+}
+
+// commit 在事务中执行修改并读取修改后的行（删除后为nil），配置了版本表时同时记录版本，提交后重建路由注册表
+// 修改未影响任何行时返回 sql.ErrNoRows
+func (q tableQuery) commit(c *gin.Context, db *sqlx.DB, name, method string, old Map, note string, write func(tx *sqlx.Tx) (int64, error)) (Map, error) {
+	ctx := c.Request.Context()
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	n, err := write(tx)
+	if err == nil && n == 0 {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	cur, err := q.get(ctx, tx, name, method)
+	if errors.Is(err, sql.ErrNoRows) {
+		cur, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Admin.Versions != "" {
+		if err := q.recordVersion(ctx, tx, name, method, old, cur, c.GetString("userName"), note); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	reloadRegistry()
+	return cur, nil
+}
+
+// validateRoute 解析路由定义并编译模板，校验失败返回400
+func validateRoute(row Map, key string) (*Route, *ApiError) {
+	r, err := parseRoute(func(name string, _ int) any {
+		if v, ok := row[name]; ok && v != nil {
+			return v
+		}
+		return ""
+	})
+	var ae *ApiError
+	if err == nil {
+		if err = registry.Load().compile(key, r); err == nil {
+			return r, nil
+		}
+	}
+	if errors.As(err, &ae) {
+		return nil, errBadRequest.with(ae.Message, ae.Details)
+	}
+	return nil, errBadRequest.with("路由定义无效", err)
+}
+
+// dryRun 以默认值与给定参数渲染模板，并在路由数据源的事务中执行后回滚
+func dryRun(ctx context.Context, r *Route, params Map) (Map, error) {
+	param := Map{}
+	for k, v := range r.Defaults {
+		param[k] = v
+	}
+	for k, v := range params {
+		param[k] = v
+	}
+	src, err := source(r.Ds)
+	if err != nil {
+		return nil, err
+	}
+	sqlstr, args, err := render(r.tmpl, src.conf.Driver, param)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := src.db.Load().BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := queryRows(ctx, tx, true, sqlstr, args...)
+	if err != nil {
+		return nil, err
+	}
+	return Map{"sql": sqlstr, "args": args, "columns": res.Cols, "rows": len(res.Rows)}, nil
+}
+
+// tableQuery 按元数据源驱动生成路由表的SQL
+type tableQuery struct {
+	driver string
+	table  string
+}
+
+// adminDB 返回元数据源连接与路由表SQL生成器
+func adminDB() (*sqlx.DB, tableQuery, error) {
+	meta, err := source(cfg.Meta)
+	if err != nil {
+		return nil, tableQuery{}, err
+	}
+	// 表名按配置原样使用，与 query 中的写法保持一致
+	q := tableQuery{driver: meta.conf.Driver, table: cfg.Admin.Table}
+	return meta.db.Load(), q, nil
+}
+
+// ident 按驱动为标识符加引号
+func (q tableQuery) ident(name string) string {
+	switch q.driver {
+	case "mysql":
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	case "postgres":
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// columns 返回路由表的全部列名
+func (q tableQuery) columns(ctx context.Context, db sqlx.ExtContext) (map[string]bool, error) {
+	rows, err := db.QueryxContext(ctx, "SELECT * FROM "+q.table+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	cols := make(map[string]bool, len(names))
+	for _, n := range names {
+		cols[n] = true
+	}
+	return cols, err
+}
+
+// get 按路由与方法读取一行
+func (q tableQuery) get(ctx context.Context, db sqlx.ExtContext, name, method string) (Map, error) {
+	sqlstr := "SELECT * FROM " + q.table + " WHERE " + q.ident("路由") + " = ? AND " + q.ident("方法") + " = ?"
+	res, err := queryRows(ctx, db, true, db.Rebind(sqlstr), name, strings.ToUpper(method))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Rows) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return res.Rows[0], nil
+}
+
+// insert 生成插入语句，值按键的字母顺序绑定
+func (q tableQuery) insert(row Map) (string, []any) {
+	keys := sortedKeys(row)
+	cols, marks, args := make([]string, len(keys)), make([]string, len(keys)), make([]any, len(keys))
+	for i, k := range keys {
+		cols[i], marks[i], args[i] = q.ident(k), "?", row[k]
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", q.table, strings.Join(cols, ", "), strings.Join(marks, ", ")), args
+}
+
+// update 生成按路由与方法更新的语句，SET的值按键的字母顺序绑定，其后为路由与方法
+func (q tableQuery) update(row Map) (string, []any) {
+	keys := sortedKeys(row)
+	sets, args := make([]string, len(keys)), make([]any, len(keys))
+	for i, k := range keys {
+		sets[i], args[i] = q.ident(k)+" = ?", row[k]
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s = ? AND %s = ?", q.table, strings.Join(sets, ", "), q.ident("路由"), q.ident("方法")), args
+}
+
+// exec 执行语句并返回受影响的行数
+func (q tableQuery) exec(ctx context.Context, db sqlx.ExtContext, sqlstr string, args ...any) (int64, error) {
+	res, err := db.ExecContext(ctx, db.Rebind(sqlstr), args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2" // TOML配置解析
+	"gopkg.in/yaml.v3"                // YAML配置解析
+)
+
+// envPrefix 环境变量覆盖的前缀，如 APIGO_DSN 覆盖 dsn
+const envPrefix = "APIGO"
+
+// envRef 匹配配置值中的 ${ENV} 环境变量引用
+var envRef = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// drivers 支持的数据库驱动
+var drivers = map[string]bool{"mssql": true, "mysql": true, "postgres": true}
+
+// loadConfig 读取配置文件，依次应用环境变量覆盖、密钥引用解析和启动校验
+// 所有错误合并后一次性返回，便于一次修正全部配置问题
+func loadConfig(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("无法读取配置文件: %w", err)
+	}
+
+	// YAML/TOML先解析为通用结构，再转为JSON，三种格式共用一套json字段名
+	raw := make(Map)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		err = json.Unmarshal(b, &raw)
+	}
+	if err == nil {
+		b, err = json.Marshal(raw)
+	}
+	if err != nil {
+		return fmt.Errorf("配置文件格式错误: %w", err)
+	}
+
+	// 严格解码，拒绝未知字段以暴露拼写错误
+	var errs []error
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		errs = append(errs, fmt.Errorf("配置文件格式错误: %w", err))
+	}
+
+	errs = append(errs, walkCfg(reflect.ValueOf(cfg).Elem(), envPrefix, applyEnv)...)
+	errs = append(errs, walkCfg(reflect.ValueOf(cfg).Elem(), envPrefix, resolveRef)...)
+	cfg.normalize()
+	errs = append(errs, cfg.validate()...)
+	return errors.Join(errs...)
+}
+
+// walkCfg 按json标签遍历配置字段，key为对应的环境变量名，如 APIGO_JWTSECRET
+func walkCfg(v reflect.Value, key string, fn func(key string, f reflect.Value) error) (errs []error) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			errs = walkCfg(v.Elem(), key, fn)
+		} else if v.Type().Elem().Kind() != reflect.Struct {
+			// 未设置的可选值（如 cors.credentials），仍可由环境变量设置
+			if err := fn(key, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			tag := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if tag != "" && tag != "-" {
+				errs = append(errs, walkCfg(v.Field(i), key+"_"+strings.ToUpper(tag), fn)...)
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			errs = append(errs, walkCfg(v.MapIndex(k), key+"_"+strings.ToUpper(k.String()), fn)...)
+		}
+	case reflect.Slice:
+		// 先整体处理（环境变量以逗号分隔覆盖），再逐个元素处理，使 replicas 等列表中的 ${ENV}/file: 引用同样生效
+		if err := fn(key, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, walkCfg(v.Index(i), key+"_"+strconv.Itoa(i), fn)...)
+		}
+	default:
+		if err := fn(key, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return
+}
+
+// applyEnv 若设置了对应环境变量，则用其值覆盖配置字段
+func applyEnv(key string, f reflect.Value) error {
+	s, ok := os.LookupEnv(key)
+	if !ok || !f.CanSet() {
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("无效整数 %q", s)
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("无效数字 %q", s)
+		}
+		f.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("无效布尔值 %q", s)
+		}
+		f.SetBool(b)
+	case reflect.Slice:
+		f.Set(reflect.ValueOf(strings.Split(s, ",")))
+	case reflect.Pointer:
+		v := reflect.New(f.Type().Elem())
+		if err := applyEnv(key, v.Elem()); err != nil {
+			return err
+		}
+		f.Set(v)
+	}
+	return nil
+}
+
+// resolveRef 解析字符串字段中的 ${ENV} 环境变量引用和 file: 文件引用
+func resolveRef(_ string, f reflect.Value) error {
+	if f.Kind() != reflect.String || !f.CanSet() {
+		return nil
+	}
+	var err error
+	s := envRef.ReplaceAllStringFunc(f.String(), func(m string) string {
+		v, ok := os.LookupEnv(m[2 : len(m)-1])
+		if !ok {
+			err = fmt.Errorf("环境变量 %s 未设置", m[2:len(m)-1])
+		}
+		return v
+	})
+	if err != nil {
+		return err
+	}
+	// file: 引用读取文件内容作为值，常用于密钥文件
+	if p, ok := strings.CutPrefix(s, "file:"); ok {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("无法读取密钥文件: %w", err)
+		}
+		s = strings.TrimSpace(string(b))
+	}
+	f.SetString(s)
+	return nil
+}
+
+// normalize 补全默认值：顶层 driver/dsn 作为default数据源，元数据源默认default
+func (c *Cfg) normalize() {
+	if c.Datasources == nil {
+		c.Datasources = make(map[string]*DataSource)
+	}
+	if _, ok := c.Datasources["default"]; !ok && (c.Driver != "" || c.Dsn != "") {
+		c.Datasources["default"] = &DataSource{Driver: c.Driver, Dsn: c.Dsn}
+	}
+	if c.Meta == "" {
+		c.Meta = "default"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 30
+	}
+	if len(c.ParamOrder) == 0 {
+		c.ParamOrder = []string{"path", "body", "query", "header"}
+	}
+	if c.Reload == 0 {
+		c.Reload = 60
+	}
+	if m := fromTable.FindStringSubmatch(c.Query); c.Admin.Table == "" && m != nil {
+		c.Admin.Table = m[1]
+	}
+	if c.Upload.MaxSize == 0 {
+		c.Upload.MaxSize = 10
+	}
+	if c.Upload.MaxFiles == 0 {
+		c.Upload.MaxFiles = 10
+	}
+	if len(c.Upload.Roots) == 0 && c.Upload.Storage == "local" {
+		c.Upload.Roots = []string{c.Upload.Dir}
+	}
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+	if c.Log.MaxSize == 0 {
+		c.Log.MaxSize = 100
+	}
+	if c.Log.MaxBackups == 0 {
+		c.Log.MaxBackups = 10
+	}
+	if c.Log.MaxAge == 0 {
+		c.Log.MaxAge = 30
+	}
+	if c.Log.Redact == nil {
+		c.Log.Redact = []string{"password", "token", "code", "secret"}
+	}
+	if c.Server.Drain == 0 {
+		c.Server.Drain = 30
+	}
+	if c.Server.Ready == 0 {
+		c.Server.Ready = 60
+	}
+	if c.TLS.MinVersion == "" {
+		c.TLS.MinVersion = "1.2"
+	}
+	if c.TLS.Watch == 0 {
+		c.TLS.Watch = 10
+	}
+	if c.Health.Timeout == 0 {
+		c.Health.Timeout = 3
+	}
+	if c.Trace.Endpoint == "" {
+		c.Trace.Endpoint = "localhost:4318"
+	}
+	if c.Trace.Sample == 0 {
+		c.Trace.Sample = 1
+	}
+	if c.Trace.Service == "" {
+		c.Trace.Service = "apigo"
+	}
+	if c.Upload.S3.Region == "" {
+		c.Upload.S3.Region = "us-east-1"
+	}
+	// 跨域策略：路由配置 > 顶层cors > 内置默认值，默认允许的请求头包含模板参数请求头
+	def := defaultCors
+	def.Headers = append(def.Headers[:len(def.Headers):len(def.Headers)], c.Headers...)
+	c.Cors = c.Cors.withDefaults(def)
+	for _, r := range c.Cors.Routes {
+		if r != nil {
+			*r = r.withDefaults(c.Cors)
+		}
+	}
+	// 连接池参数：数据源自身配置 > 顶层pool > 内置默认值
+	c.Pool = c.Pool.withDefaults(defaultPool)
+	for _, ds := range c.Datasources {
+		if ds != nil {
+			ds.Pool = ds.Pool.withDefaults(c.Pool)
+		}
+	}
+}
+
+// withDefaults 用 def 补全未设置(为0)的连接池参数
+func (p Pool) withDefaults(def Pool) Pool {
+	v, d := reflect.ValueOf(&p).Elem(), reflect.ValueOf(def)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			v.Field(i).Set(d.Field(i))
+		}
+	}
+	return p
+}
+
+// checkDsn 打开再关闭连接池以解析连接字符串，不实际连接数据库
+func checkDsn(driver, dsn string) error {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// validate 校验配置，返回全部缺失或无效的字段
+func (c *Cfg) validate() (errs []error) {
+	check := func(ok bool, format string, a ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, a...))
+		}
+	}
+	check(len(c.Datasources) > 0, "datasources: 至少需要配置一个数据源，或配置顶层 driver/dsn")
+	for _, name := range sortedKeys(c.Datasources) {
+		ds := c.Datasources[name]
+		if ds == nil {
+			ds = new(DataSource)
+		}
+		check(drivers[ds.Driver], "datasources.%s.driver: 不支持的数据库驱动，可选 mssql/mysql/postgres", name)
+		check(ds.Dsn != "", "datasources.%s.dsn: 不能为空", name)
+		if drivers[ds.Driver] {
+			if ds.Dsn != "" {
+				err := checkDsn(ds.Driver, ds.Dsn)
+				check(err == nil, "datasources.%s.dsn: 无效的连接字符串: %v", name, err)
+			}
+			for i, dsn := range ds.Replicas {
+				err := checkDsn(ds.Driver, dsn)
+				check(err == nil, "datasources.%s.replicas[%d]: 无效的连接字符串: %v", name, i, err)
+			}
+		}
+		check(ds.Pool.MaxOpen >= 0 && ds.Pool.MaxIdle >= 0 && ds.Pool.MaxLifetime >= 0 && ds.Pool.MaxIdleTime >= 0,
+			"datasources.%s.pool: 连接池参数不能为负数", name)
+		check(ds.Pool.Health > 0 && ds.Pool.Retries > 0 && ds.Pool.Backoff > 0 && ds.Pool.MaxBackoff >= ds.Pool.Backoff,
+			"datasources.%s.pool: health/retries/backoff 必须大于0，且 maxBackoff 不小于 backoff", name)
+		check(ds.Balance == "" || ds.Balance == "roundrobin" || ds.Balance == "leastconn",
+			"datasources.%s.balance: 无效的副本选择策略 %q，可选 roundrobin/leastconn", name, ds.Balance)
+	}
+	check(c.Datasources[c.Meta] != nil, "meta: 元数据源[%s]不存在", c.Meta)
+	check(c.Query != "", "query: 不能为空")
+	check(strings.Contains(c.Api, ":a"), "api: 路由路径必须包含 :a 参数，如 /api/:a")
+	check(c.Port > 0 && c.Port < 65536, "port: 无效端口 %d", c.Port)
+	check(c.Timeout > 0, "timeout: 查询超时必须大于0")
+	seen := make(map[string]bool)
+	for _, o := range c.ParamOrder {
+		check((o == "path" || o == "body" || o == "query" || o == "header") && !seen[o],
+			"paramOrder: 无效或重复的参数来源 %q，可选 path/body/query/header", o)
+		seen[o] = true
+	}
+	check(c.Reload > 0, "reload: 路由注册表重建间隔必须大于0")
+	switch u := c.Upload; u.Storage {
+	case "":
+	case "local":
+		check(u.Dir != "", "upload.dir: local存储必须配置保存目录")
+	case "s3":
+		check(u.S3.Endpoint != "" && u.S3.Bucket != "" && u.S3.AccessKey != "" && u.S3.SecretKey != "",
+			"upload.s3: s3存储必须配置 endpoint/bucket/accessKey/secretKey")
+	default:
+		check(false, "upload.storage: 不支持的存储后端 %q，可选 local/s3", u.Storage)
+	}
+	check(c.Upload.MaxSize > 0 && c.Upload.MaxFiles > 0, "upload: maxSize/maxFiles 必须大于0")
+	_, ok := logLevels[c.Log.Level]
+	check(ok, "log.level: 无效的日志级别 %q，可选 debug/info/warn/error", c.Log.Level)
+	check(c.Log.MaxSize > 0 && c.Log.MaxBackups >= 0 && c.Log.MaxAge >= 0, "log: maxSize必须大于0，maxBackups/maxAge不能为负数")
+	check(c.Server.Drain > 0, "server.drain: 退出等待时间必须大于0")
+	check(c.Server.PreStop >= 0, "server.preStop: 摘除等待时间不能为负数")
+	check(c.Server.Ready > 0, "server.ready: 新进程就绪等待时间必须大于0")
+	check(!c.Server.ReusePort || runtime.GOOS == "linux", "server.reusePort: 仅Linux支持")
+	check((c.TLS.Cert == "") == (c.TLS.Key == ""), "tls: cert与key必须同时配置")
+	check(c.TLS.ClientCA == "" || c.TLS.Cert != "", "tls.clientCA: 启用mTLS必须同时配置cert与key")
+	_, ok = tlsVersions[c.TLS.MinVersion]
+	check(ok, "tls.minVersion: 无效的TLS版本 %q，可选 1.2/1.3", c.TLS.MinVersion)
+	check(c.TLS.ClientAuth == "" || c.TLS.ClientAuth == "optional" || c.TLS.ClientAuth == "require",
+		"tls.clientAuth: 无效的客户端证书要求 %q，可选 optional/require", c.TLS.ClientAuth)
+	check(c.TLS.Watch > 0, "tls.watch: 证书检查间隔必须大于0")
+	if err := c.Cors.validate(); err != nil {
+		check(false, "cors: %v", err)
+	}
+	for _, name := range sortedKeys(c.Cors.Routes) {
+		r := c.Cors.Routes[name]
+		if r == nil || r.Routes != nil {
+			check(false, "cors.routes.%s: 必须是跨域策略对象，且不能嵌套routes", name)
+		} else if err := r.validate(); err != nil {
+			check(false, "cors.routes.%s: %v", name, err)
+		} else {
+			_, err := path.Match(name, "")
+			check(err == nil, "cors.routes.%s: 无效的通配符", name)
+		}
+	}
+	check(c.Health.Timeout > 0, "health.timeout: 就绪检查超时必须大于0")
+	check(c.Trace.Exporter == "" || c.Trace.Exporter == "otlp" || c.Trace.Exporter == "stdout", "trace.exporter: 不支持的导出方式 %q，可选 otlp/stdout", c.Trace.Exporter)
+	check(c.Trace.Sample > 0 && c.Trace.Sample <= 1, "trace.sample: 采样比例必须在 (0, 1] 之间")
+	check(len(c.Admin.Users) == 0 || c.Admin.Table != "", "admin.table: 无法从 query 解析路由表名，请配置 admin.table")
+	check(c.JWTSecret != "", "jwtSecret: 不能为空")
+	check(c.JWTExpire > 0, "jwtExpire: 必须大于0")
+
+	// 微信接口地址为可选项，配置时必须是合法的http(s)地址
+	for _, kv := range [][2]string{
+		{"wechatTokenUrl", c.WechatTokenUrl},
+		{"wechatAccessTokenUrl", c.WechatAccessTokenUrl},
+		{"wechatTicketUrl", c.WechatTicketUrl},
+	} {
+		u, err := url.Parse(kv[1])
+		check(kv[1] == "" || (err == nil && (u.Scheme == "http" || u.Scheme == "https")), "%s: 无效地址 %q", kv[0], kv[1])
+	}
+	return
+}
+
+// sortedKeys 返回按字母排序的键，保证校验与启动顺序稳定
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/gin-contrib/cors" // 跨域资源共享中间件
+	"github.com/gin-gonic/gin"    // Web框架
+)
+
+// Cors 定义跨域策略，routes 按路由名覆盖，其中未设置的项使用顶层策略；凭据与缓存时间为指针，以区分未设置与显式的false/0
+type Cors struct {
+	Origins       []string         `json:"origins"`       // 允许的源，如 https://h5.example.com、https://*.example.com，* 表示全部，默认*
+	Methods       []string         `json:"methods"`       // 允许的方法，默认 GET/POST/PUT/PATCH/DELETE/HEAD/OPTIONS
+	Headers       []string         `json:"headers"`       // 允许的请求头，默认含Authorization与 headers 中的模板参数请求头
+	ExposeHeaders []string         `json:"exposeHeaders"` // 前端可读取的响应头，默认 X-Request-ID/Content-Disposition
+	Credentials   *bool            `json:"credentials"`   // 允许携带Cookie等凭据，默认false，不能与源*同时使用
+	MaxAge        *int             `json:"maxAge"`        // 预检结果缓存时间（秒），默认43200，0表示不缓存
+	Routes        map[string]*Cors `json:"routes"`        // 按路由名覆盖的策略，键可用通配符，如 wx_*
+}
+
+// defaultCors 跨域策略的内置默认值
+var defaultCors = Cors{
+	Origins:       []string{"*"},
+	Methods:       []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+	Headers:       []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-Request-ID"},
+	ExposeHeaders: []string{"X-Request-ID", "Content-Disposition"},
+	Credentials:   new(bool),
+	MaxAge:        &defaultMaxAge,
+}
+
+// defaultMaxAge 预检结果缓存时间的内置默认值（秒）
+var defaultMaxAge = 43200
+
+// withDefaults 用 def 补全未设置的策略项，不继承 routes
+func (p Cors) withDefaults(def Cors) Cors {
+	v, d := reflect.ValueOf(&p).Elem(), reflect.ValueOf(def)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() && v.Type().Field(i).Name != "Routes" {
+			v.Field(i).Set(d.Field(i))
+		}
+	}
+	return p
+}
+
+// config 转换为CORS中间件配置，须先经 withDefaults 补全
+func (p *Cors) config() cors.Config {
+	conf := cors.Config{
+		AllowMethods:     p.Methods,
+		AllowHeaders:     p.Headers,
+		ExposeHeaders:    p.ExposeHeaders,
+		AllowCredentials: *p.Credentials,
+		MaxAge:           seconds(*p.MaxAge),
+		AllowWildcard:    true,
+	}
+	for _, o := range p.Origins {
+		if o == "*" {
+			conf.AllowAllOrigins = true
+			return conf
+		}
+	}
+	conf.AllowOrigins = p.Origins
+	return conf
+}
+
+// validate 校验策略，返回第一个错误；允许凭据时必须列出具体的源，避免任意网站携带用户凭据调用接口
+func (p *Cors) validate() error {
+	for _, o := range p.Origins {
+		if strings.Count(o, "*") > 1 {
+			return fmt.Errorf("源 %q 中最多只能有一个 *", o)
+		}
+		if o == "*" && *p.Credentials {
+			return fmt.Errorf("credentials为true时源不能为*，请列出允许的源")
+		}
+	}
+	if *p.MaxAge < 0 {
+		return fmt.Errorf("maxAge不能为负数")
+	}
+	return p.config().Validate()
+}
+
+// configureCORS 按配置生成跨域中间件：API路由（含固定版本路径）先按路由名精确匹配 cors.routes，再按通配符匹配，其余请求使用顶层策略
+func configureCORS() gin.HandlerFunc {
+	def := cors.New(cfg.Cors.config())
+	routes := make(map[string]gin.HandlerFunc, len(cfg.Cors.Routes))
+	for name, p := range cfg.Cors.Routes {
+		routes[name] = cors.New(p.config())
+	}
+	patterns := sortedKeys(cfg.Cors.Routes)
+	return func(c *gin.Context) {
+		if a, _, _ := routeName(c); a != "" {
+			if h, ok := routes[a]; ok {
+				h(c)
+				return
+			}
+			for _, p := range patterns {
+				if ok, _ := path.Match(p, a); ok {
+					routes[p](c)
+					return
+				}
+			}
+		}
+		def(c)
+	}
+}
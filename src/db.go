@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"           // Web框架
+	"github.com/jmoiron/sqlx"            // 增强的数据库操作包
+	"go.opentelemetry.io/otel/attribute" // 链路属性
+	"go.opentelemetry.io/otel/trace"     // 链路API
+)
+
+type (
+	// DataSource 定义一个命名数据源的配置
+	DataSource struct {
+		Driver   string   `json:"driver"`   // 数据库驱动类型：mssql/mysql/postgres
+		Dsn      string   `json:"dsn"`      // 数据库连接字符串
+		Replicas []string `json:"replicas"` // 只读副本连接字符串，驱动与主库相同
+		Balance  string   `json:"balance"`  // 副本选择策略：roundrobin(默认)/leastconn
+		Pool     Pool     `json:"pool"`     // 连接池与重连策略，未设置的项使用顶层pool
+	}
+	// Pool 定义连接池参数与重连策略，时间单位均为秒
+	Pool struct {
+		MaxOpen     int `json:"maxOpen"`     // 最大打开连接数，默认25
+		MaxIdle     int `json:"maxIdle"`     // 最大空闲连接数，默认25
+		MaxLifetime int `json:"maxLifetime"` // 连接最大生命周期，默认300
+		MaxIdleTime int `json:"maxIdleTime"` // 空闲连接最大保留时间，默认0不限制
+		Health      int `json:"health"`      // 健康检查间隔，默认60
+		Retries     int `json:"retries"`     // 每轮重连最大尝试次数，默认5
+		Backoff     int `json:"backoff"`     // 重连初始等待时间，每次失败翻倍，默认1
+		MaxBackoff  int `json:"maxBackoff"`  // 重连最大等待时间，默认30
+	}
+	// Source 是一个已连接的命名数据源，连接池句柄可原子替换
+	Source struct {
+		name     string
+		conf     *DataSource
+		db       atomic.Pointer[sqlx.DB]
+		ready    atomic.Bool // 主库是否可用，不可用时路由返回503
+		replicas []*Replica
+		next     atomic.Uint64 // 轮询计数
+	}
+	// Replica 是数据源的一个只读副本，健康检查失败时不参与查询
+	Replica struct {
+		name    string
+		db      *sqlx.DB
+		healthy atomic.Bool
+	}
+	// Route 描述API表中的一条路由定义，字段对应 cfg.Query 查询出的列
+	Route struct {
+		Tmpl     string   // 模板：SQL语法模板
+		Auth     int      // 鉴权：0匿名，1需要JWT
+		Ds       string   // 数据源：为空时使用元数据源
+		Mode     string   // 模式：query走只读副本，tx在主库事务中执行，file返回文件内容，其余在主库执行
+		Timeout  int      // 超时：查询超时秒数，为0时使用配置的默认超时
+		Defaults Map      // 默认值：JSON对象，请求未提供的参数使用其中的值
+		Shape    *Shape   // 结构：JSON对象，按分组、嵌套与重命名整形查询结果
+		Result   string   // 结果：list数组(默认)，one单个对象，scalar首行首列，none只执行
+		Uploads  []string // 上传：允许上传文件的字段名，逗号分隔，为空时不接收文件
+
+		tmpl *template.Template // 已编译的模板，由路由注册表生成
+	}
+	// Result 是一次SQL执行的结果
+	Result struct {
+		Cols     []string // 列名，按查询输出的顺序
+		Rows     []Map    // 数据行
+		Affected int64    // 结果模式为none时受影响的行数
+	}
+)
+
+// defaultPool 连接池参数的内置默认值
+var defaultPool = Pool{MaxOpen: 25, MaxIdle: 25, MaxLifetime: 300, Health: 60, Retries: 5, Backoff: 1, MaxBackoff: 30}
+
+// errUnavailable 数据源暂不可用，对外返回503
+var errUnavailable = errors.New("暂不可用")
+
+// sources 所有命名数据源，启动后只读
+var sources = map[string]*Source{}
+
+// initDB 为每个命名数据源初始化独立的连接池
+// 连接失败不终止进程，数据源降级为不可用，由健康检查按退避策略重连
+func initDB() {
+	for _, name := range sortedKeys(cfg.Datasources) {
+		conf := cfg.Datasources[name]
+		s := &Source{name: name, conf: conf}
+		sources[name] = s
+
+		// 连接数据库
+		db, err := s.connect(conf.Dsn)
+		if err != nil {
+			slog.Error("无法连接到数据源", "source", name, "error", err)
+		}
+		s.db.Store(db)
+		s.ready.Store(err == nil)
+
+		// 副本连接失败同样不影响启动
+		for i, dsn := range conf.Replicas {
+			r := &Replica{name: fmt.Sprintf("%s#%d", name, i)}
+			r.db, _ = s.connect(dsn)
+			r.check()
+			s.replicas = append(s.replicas, r)
+		}
+
+		// 启动一个goroutine定期检查数据库连接
+		go s.watch()
+	}
+}
+
+// connect 按数据源的连接池参数打开并检查连接
+func (s *Source) connect(dsn string) (*sqlx.DB, error) {
+	db, err := sqlx.Open(s.conf.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	p := s.conf.Pool
+	db.SetMaxOpenConns(p.MaxOpen)                 // 最大打开连接数
+	db.SetMaxIdleConns(p.MaxIdle)                 // 最大空闲连接数
+	db.SetConnMaxLifetime(seconds(p.MaxLifetime)) // 连接最大生命周期
+	db.SetConnMaxIdleTime(seconds(p.MaxIdleTime)) // 空闲连接最大保留时间
+	return db, db.Ping()
+}
+
+// watch 定期检查主库与副本，主库不可用时降级并重连
+func (s *Source) watch() {
+	for {
+		time.Sleep(seconds(s.conf.Pool.Health))
+		if err := ping(s.db.Load()); err != nil {
+			slog.Warn("数据源连接丢失，尝试重连", "source", s.name, "error", err)
+			s.ready.Store(false)
+			s.reconnect()
+		} else if !s.ready.Swap(true) {
+			slog.Info("数据源已恢复", "source", s.name)
+		}
+		for _, r := range s.replicas {
+			r.check()
+		}
+	}
+}
+
+// reconnect 按退避策略重建连接池，成功后原子替换句柄并关闭旧连接池
+// 本轮全部失败时保持降级状态，等待下一次健康检查继续重连
+func (s *Source) reconnect() {
+	p := s.conf.Pool
+	delay := seconds(p.Backoff)
+	var err error
+	for i := 0; i < p.Retries; i++ {
+		var db *sqlx.DB
+		if db, err = s.connect(s.conf.Dsn); err == nil {
+			if old := s.db.Swap(db); old != nil {
+				old.Close()
+			}
+			s.ready.Store(true)
+			slog.Info("数据源重连成功", "source", s.name)
+			return
+		}
+		if db != nil {
+			db.Close()
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > seconds(p.MaxBackoff) {
+			delay = seconds(p.MaxBackoff)
+		}
+	}
+	slog.Error("数据源重连失败，保持降级状态", "source", s.name, "error", err)
+}
+
+// check 检查副本健康状态，状态变化时记录日志
+func (r *Replica) check() {
+	err := ping(r.db)
+	if r.healthy.Swap(err == nil) != (err == nil) {
+		if err != nil {
+			slog.Warn("只读副本不可用，查询回退主库", "replica", r.name, "error", err)
+		} else {
+			slog.Info("只读副本已恢复", "replica", r.name)
+		}
+	}
+}
+
+// ping 检查连接池，连接字符串无效导致未能打开时返回错误而非空指针异常
+func ping(db *sqlx.DB) error {
+	if db == nil {
+		return errors.New("连接池未打开")
+	}
+	return db.Ping()
+}
+
+// closeDB 关闭全部数据源与副本的连接池，退出前调用
+func closeDB() {
+	for _, s := range sources {
+		s.ready.Store(false)
+		if db := s.db.Load(); db != nil {
+			db.Close()
+		}
+		for _, r := range s.replicas {
+			if r.db != nil {
+				r.db.Close()
+			}
+		}
+	}
+}
+
+// Stats 返回所有数据源及副本的连接池统计，供 /stats 接口输出
+func Stats(c *gin.Context) {
+	data := make(Map, len(sources))
+	for name, s := range sources {
+		replicas := make([]Map, 0, len(s.replicas))
+		for _, r := range s.replicas {
+			replicas = append(replicas, Map{"name": r.name, "healthy": r.healthy.Load(), "stats": dbStats(r.db)})
+		}
+		data[name] = Map{"ready": s.ready.Load(), "stats": dbStats(s.db.Load()), "replicas": replicas}
+	}
+	c.JSON(http.StatusOK, Map{"data": data, "status": 0})
+}
+
+// dbStats 返回连接池统计，未打开的连接池返回空统计
+func dbStats(db *sqlx.DB) sql.DBStats {
+	if db == nil {
+		return sql.DBStats{}
+	}
+	return db.Stats()
+}
+
+// seconds 将配置中的秒数转换为时间间隔
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// reader 按策略选择健康的只读副本，没有可用副本时回退主库
+func (s *Source) reader() *sqlx.DB {
+	n := len(s.replicas)
+	if n == 0 {
+		return s.db.Load()
+	}
+	var best *Replica
+	start := int(s.next.Add(1) % uint64(n))
+	for i := 0; i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if !r.healthy.Load() {
+			continue
+		}
+		if s.conf.Balance != "leastconn" {
+			return r.db
+		}
+		if best == nil || r.db.Stats().InUse < best.db.Stats().InUse {
+			best = r
+		}
+	}
+	if best != nil {
+		return best.db
+	}
+	return s.db.Load()
+}
+
+// run 按路由模式执行SQL：query走只读副本，tx在主库事务中执行，其余直接在主库执行
+// file 模式同样走只读副本，且保留原始值，二进制列不做转换；结果模式为 none 时只执行不读取结果，且总在主库执行
+// ctx 取消或超时时查询在数据库端同时被取消；sqlstr中的占位符已在渲染时按驱动生成
+func (s *Source) run(ctx context.Context, r *Route, sqlstr string, args ...any) (res *Result, err error) {
+	ctx, span := tracer.Start(ctx, "query", trace.WithSpanKind(trace.SpanKindClient))
+	if span.IsRecording() {
+		span.SetAttributes(dbSystems[s.conf.Driver], attribute.String("db.source", s.name), attribute.String("db.mode", r.Mode), attribute.String("db.fingerprint", fingerprint(sqlstr)))
+	}
+	defer func(start time.Time) {
+		dbDuration.WithLabelValues(s.name, r.Mode).Observe(time.Since(start).Seconds())
+		endSpan(span, err)
+	}(time.Now())
+	do := func(q sqlx.ExtContext) (*Result, error) {
+		if r.Result == "none" {
+			res, err := q.ExecContext(ctx, sqlstr, args...)
+			if err != nil {
+				return nil, err
+			}
+			n, _ := res.RowsAffected()
+			return &Result{Affected: n}, nil
+		}
+		return queryRows(ctx, q, r.Mode != "file", sqlstr, args...)
+	}
+	switch r.Mode {
+	case "query", "file":
+		if r.Result != "none" {
+			return do(s.reader())
+		}
+	case "tx":
+		tx, err := s.db.Load().BeginTxx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		res, err := do(tx)
+		if err == nil {
+			err = tx.Commit()
+		}
+		return res, err
+	}
+	return do(s.db.Load())
+}
+
+// explain 返回SQL的执行计划而不执行：mssql 在独占连接上开启 SHOWPLAN_TEXT，mysql/postgres 使用 EXPLAIN
+func (s *Source) explain(ctx context.Context, sqlstr string, args ...any) (*Result, error) {
+	db := s.db.Load()
+	if s.conf.Driver != "mssql" {
+		return queryRows(ctx, db, true, "EXPLAIN "+sqlstr, args...)
+	}
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET SHOWPLAN_TEXT ON"); err != nil {
+		return nil, err
+	}
+	// 归还连接池前关闭，避免后续请求只得到执行计划；关闭失败时丢弃该连接而非归还连接池
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SET SHOWPLAN_TEXT OFF"); err != nil {
+			slog.Warn("关闭SHOWPLAN失败，丢弃连接", "source", s.name, "error", err)
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+	return queryRows(ctx, conn, true, sqlstr, args...)
+}
+
+// queryRows 执行查询并将每行转换为Map，conv为真时转换为适合JSON输出的格式
+func queryRows(ctx context.Context, q sqlx.QueryerContext, conv bool, sqlstr string, args ...any) (res *Result, err error) {
+	rows, err := q.QueryxContext(ctx, sqlstr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	_, span := tracer.Start(ctx, "scan")
+	defer func() { endSpan(span, err) }()
+
+	res = &Result{Rows: make([]Map, 0)}
+	if res.Cols, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		mp := make(Map)
+		if err := rows.MapScan(mp); err != nil {
+			return nil, err
+		}
+		// 转换值的格式
+		if conv {
+			for k, val := range mp {
+				mp[k] = Conv(val)
+			}
+		}
+		res.Rows = append(res.Rows, mp)
+	}
+	return res, rows.Err()
+}
+
+// source 按名称获取数据源，名称为空时使用元数据源
+func source(name string) (*Source, error) {
+	if name == "" {
+		name = cfg.Meta
+	}
+	s, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("数据源[%s]不存在", name)
+	}
+	if !s.ready.Load() {
+		return nil, fmt.Errorf("数据源[%s]%w", name, errUnavailable)
+	}
+	return s, nil
+}
+
+// getRoute 从元数据源读取路由定义
+// cfg.Query 按列名读取 模板/鉴权/数据源/模式/超时/默认值/结构/结果，未命名时按前两列兼容旧的 "模板, 鉴权" 写法
+func getRoute(ctx context.Context, action, method string) (*Route, error) {
+	meta, err := source(cfg.Meta)
+	if err != nil {
+		return nil, err
+	}
+	db := meta.db.Load()
+	rows, err := db.QueryxContext(ctx, db.Rebind(cfg.Query), action, method)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return nil, err
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	vals, err := rows.SliceScan()
+	if err != nil {
+		return nil, err
+	}
+	mp := make(Map, len(cols))
+	for i, col := range cols {
+		mp[col] = Conv(vals[i])
+	}
+	// 启用列为0的路由视为不存在
+	if v, ok := mp["启用"]; ok && v != "" && toInt(v) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return parseRoute(func(name string, pos int) any {
+		if v, ok := mp[name]; ok {
+			return v
+		}
+		if pos >= 0 && pos < len(vals) {
+			return Conv(vals[pos])
+		}
+		return ""
+	})
+}
+
+// parseRoute 按列读取路由定义并校验，col按列名取值，列不存在时按位置pos取值(pos<0时返回空)
+func parseRoute(col func(name string, pos int) any) (*Route, error) {
+	r := &Route{
+		Tmpl:    fmt.Sprint(col("模板", 0)),
+		Auth:    toInt(col("鉴权", 1)),
+		Ds:      fmt.Sprint(col("数据源", -1)),
+		Mode:    fmt.Sprint(col("模式", -1)),
+		Timeout: toInt(col("超时", -1)),
+		Result:  fmt.Sprint(col("结果", -1)),
+	}
+	switch r.Result {
+	case "", "list", "one", "scalar", "none":
+	default:
+		return nil, errTemplate.with("路由结果模式无效，可选 list/one/scalar/none", r.Result)
+	}
+	for _, f := range strings.Split(fmt.Sprint(col("上传", -1)), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			r.Uploads = append(r.Uploads, f)
+		}
+	}
+	if s := fmt.Sprint(col("默认值", -1)); s != "" {
+		if err := json.Unmarshal([]byte(s), &r.Defaults); err != nil {
+			return nil, errTemplate.with("路由默认值不是有效的JSON对象", err)
+		}
+	}
+	if s := fmt.Sprint(col("结构", -1)); s != "" {
+		if err := json.Unmarshal([]byte(s), &r.Shape); err != nil {
+			return nil, errTemplate.with("路由结构不是有效的JSON对象", err)
+		}
+		if err := r.Shape.check(""); err != nil {
+			return nil, errTemplate.with("路由结构无效", err)
+		}
+	}
+	return r, nil
+}
+
+// toInt 将数据库或请求中的值转换为整数，无法转换时返回0
+func toInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case bool:
+		if n {
+			return 1
+		}
+		return 0
+	case []byte:
+		return toInt(string(n))
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin" // Web框架
+)
+
+// docsHTML 内嵌的Swagger UI页面，读取 /openapi.json
+//
+//go:embed docs.html
+var docsHTML []byte
+
+// swaggerUI 内嵌的swagger-ui-dist资源（swagger-ui 5.x），随程序编译，离线可用
+//
+//go:embed swagger-ui.css swagger-ui-bundle.js
+var swaggerUI embed.FS
+
+// pathParam 匹配gin路由中的 :name 参数
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+// httpMethods OpenAPI支持的HTTP方法，API表中的其他方法（如片段）不输出
+var httpMethods = map[string]bool{"GET": true, "POST": true, "PUT": true, "DELETE": true, "PATCH": true, "HEAD": true}
+
+// Docs 输出内嵌的Swagger UI页面
+func Docs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsHTML)
+}
+
+// DocsAsset 输出Swagger UI页面引用的脚本与样式
+func DocsAsset(c *gin.Context) {
+	c.FileFromFS(c.Param("file"), http.FS(swaggerUI))
+}
+
+// OpenAPI 根据 cfg.Routes 查询出的路由生成OpenAPI 3文档
+// 未携带有效令牌时只列出匿名路由，携带有效令牌时列出全部路由
+func OpenAPI(c *gin.Context) {
+	if cfg.Routes == "" {
+		Fail(c, errNotFound.with("未配置路由列表查询routes", nil))
+		return
+	}
+	meta, err := source(cfg.Meta)
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	res, err := queryRows(c.Request.Context(), meta.db.Load(), true, cfg.Routes)
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	authed := callerClaims(c) != nil
+
+	paths := make(Map)
+	for _, row := range res.Rows {
+		name, method := rowText(row, "路由"), strings.ToUpper(rowText(row, "方法"))
+		if name == "" || !httpMethods[method] || (toInt(row["鉴权"]) == 1 && !authed) {
+			continue
+		}
+		p := pathParam.ReplaceAllStringFunc(strings.Replace(cfg.Api, ":a", name, 1), func(s string) string {
+			return "{" + s[1:] + "}"
+		})
+		if paths[p] == nil {
+			paths[p] = Map{}
+		}
+		paths[p].(Map)[strings.ToLower(method)] = operation(name, method, row)
+	}
+
+	c.JSON(http.StatusOK, Map{
+		"openapi": "3.0.3",
+		"info":    Map{"title": "APIGO", "version": version},
+		"paths":   paths,
+		"components": Map{
+			"securitySchemes": Map{"bearer": Map{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}},
+			"schemas": Map{"Error": Map{"type": "object", "properties": Map{
+				"status": Map{"type": "integer", "example": 1}, "code": Map{"type": "string"},
+				"message": Map{"type": "string"}, "details": Map{}, "requestId": Map{"type": "string"},
+			}}},
+		},
+	})
+}
+
+// operation 生成单个路由的OpenAPI操作：参数列为JSON Schema对象，GET/DELETE/HEAD展开为查询参数，其余作为请求体
+func operation(name, method string, row Map) Map {
+	text := func(k string) string { return rowText(row, k) }
+	op := Map{
+		"operationId": strings.ToLower(method) + "_" + name,
+		"summary":     text("描述"),
+		"responses": Map{
+			"200":     response(text("结果"), text("模式")),
+			"default": Map{"description": "错误", "content": Map{"application/json": Map{"schema": Map{"$ref": "#/components/schemas/Error"}}}},
+		},
+	}
+	if toInt(row["鉴权"]) == 1 {
+		op["security"] = []Map{{"bearer": []string{}}}
+	}
+	var schema Map
+	if s := text("参数"); s != "" && json.Unmarshal([]byte(s), &schema) != nil {
+		op["description"] = "参数列不是有效的JSON Schema"
+		schema = nil
+	}
+	if schema == nil {
+		return op
+	}
+	if method == "GET" || method == "DELETE" || method == "HEAD" {
+		props, _ := schema["properties"].(map[string]any)
+		required := make(map[string]bool)
+		if req, ok := schema["required"].([]any); ok {
+			for _, r := range req {
+				required[fmt.Sprint(r)] = true
+			}
+		}
+		var params []Map
+		for _, k := range sortedKeys(props) {
+			params = append(params, Map{"name": k, "in": "query", "required": required[k], "schema": props[k]})
+		}
+		op["parameters"] = params
+	} else {
+		op["requestBody"] = Map{"content": Map{
+			"application/json":                  Map{"schema": schema},
+			"application/x-www-form-urlencoded": Map{"schema": schema},
+		}}
+	}
+	return op
+}
+
+// response 按结果模式生成成功响应的结构
+func response(result, mode string) Map {
+	if mode == "file" {
+		return Map{"description": "文件内容", "content": Map{"application/octet-stream": Map{"schema": Map{"type": "string", "format": "binary"}}}}
+	}
+	var data Map
+	switch result {
+	case "one":
+		data = Map{"type": "object"}
+	case "scalar":
+		data = Map{}
+	case "none":
+		return envelope(Map{"affected": Map{"type": "integer"}})
+	default:
+		data = Map{"type": "array", "items": Map{"type": "object"}}
+	}
+	return envelope(Map{"data": data})
+}
+
+// envelope 生成 {"status": 0, ...} 成功响应
+func envelope(props Map) Map {
+	props["status"] = Map{"type": "integer", "example": 0}
+	return Map{"description": "成功", "content": Map{"application/json": Map{"schema": Map{"type": "object", "properties": props}}}}
+}
+
+// rowText 读取行中的文本列，空值返回空字符串
+func rowText(row Map, k string) string {
+	if row[k] == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(row[k]))
+}
+
+// callerClaims 解析请求携带的令牌，未携带或无效时返回nil
+func callerClaims(c *gin.Context) *Claims {
+	token := c.GetHeader("Authorization")
+	if token == "" {
+		token = c.Query("token")
+	}
+	claims, err := ParseToken(strings.TrimPrefix(token, "Bearer "))
+	if err != nil {
+		return nil
+	}
+	return claims
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb" // SQL Server驱动
+	"github.com/gin-gonic/gin"               // Web框架
+	"github.com/go-sql-driver/mysql"         // MySQL驱动
+	"github.com/lib/pq"                      // PostgreSQL驱动
+)
+
+// ApiError 是统一的错误类型，所有错误响应均输出为
+// {"status":1, "code":错误码, "message":错误信息, "details":详情(可选), "requestId":请求ID}
+type ApiError struct {
+	Status  int    // HTTP状态码
+	Code    string // 稳定的机器可读错误码
+	Message string // 面向用户的错误信息
+	Details any    // 可选的错误详情
+}
+
+// 预定义错误，使用 with 附加详情，避免修改共享实例
+var (
+	errBadRequest   = &ApiError{http.StatusBadRequest, "BAD_REQUEST", "请求参数错误", nil}
+	errParamMissing = &ApiError{http.StatusBadRequest, "PARAM_MISSING", "缺少参数", nil}
+	errUnauthorized = &ApiError{http.StatusUnauthorized, "UNAUTHORIZED", "需要授权令牌", nil}
+	errTokenInvalid = &ApiError{http.StatusUnauthorized, "TOKEN_INVALID", "无效的授权令牌", nil}
+	errLoginFailed  = &ApiError{http.StatusUnauthorized, "LOGIN_FAILED", "用户名或密码错误", nil}
+	errForbidden    = &ApiError{http.StatusForbidden, "FORBIDDEN", "没有访问权限", nil}
+	errNotFound     = &ApiError{http.StatusNotFound, "NOT_FOUND", "API不存在", nil}
+	errTooLarge     = &ApiError{http.StatusRequestEntityTooLarge, "TOO_LARGE", "上传内容过大", nil}
+	errFileType     = &ApiError{http.StatusUnsupportedMediaType, "FILE_TYPE", "不允许的文件类型", nil}
+	errDuplicate    = &ApiError{http.StatusConflict, "DUPLICATE", "数据重复，违反唯一约束", nil}
+	errForeignKey   = &ApiError{http.StatusConflict, "FOREIGN_KEY", "违反外键约束，关联数据不存在或仍被引用", nil}
+	errDeadlock     = &ApiError{http.StatusConflict, "DEADLOCK", "数据库死锁，请重试", nil}
+	errConstraint   = &ApiError{http.StatusUnprocessableEntity, "CONSTRAINT", "数据不满足约束条件", nil}
+	errInternal     = &ApiError{http.StatusInternalServerError, "INTERNAL", "服务器内部错误", nil}
+	errTemplate     = &ApiError{http.StatusInternalServerError, "TEMPLATE_INVALID", "SQL模板解析失败", nil}
+	errDatabase     = &ApiError{http.StatusInternalServerError, "DB_ERROR", "查询执行失败", nil}
+	errUpstream     = &ApiError{http.StatusBadGateway, "UPSTREAM", "上游接口调用失败", nil}
+	errNoSource     = &ApiError{http.StatusServiceUnavailable, "UNAVAILABLE", "服务暂不可用", nil}
+	errTimeout      = &ApiError{http.StatusGatewayTimeout, "TIMEOUT", "查询超时", nil}
+)
+
+// Error 实现error接口
+func (e *ApiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// with 返回附加了信息与详情的副本，msg为空时保留原信息
+func (e *ApiError) with(msg string, details any) *ApiError {
+	n := *e
+	if msg != "" {
+		n.Message = msg
+	}
+	if err, ok := details.(error); ok {
+		details = err.Error()
+	}
+	n.Details = details
+	return &n
+}
+
+// Fail 输出统一错误响应并中止后续处理
+// 生产模式下5xx错误的详情（可能含上游请求URL中的密钥等）只记录在服务端日志，不返回客户端
+func Fail(c *gin.Context, e *ApiError) {
+	c.Set("errorCode", e.Code)
+	body := Map{"status": 1, "code": e.Code, "message": e.Message, "requestId": c.GetString("requestId")}
+	if cfg.Production && e.Status >= 500 && e.Details != nil {
+		slog.ErrorContext(c, "错误详情", "requestId", c.GetString("requestId"), "code", e.Code, "details", e.Details)
+	} else if e.Details != nil {
+		body["details"] = e.Details
+	}
+	c.AbortWithStatusJSON(e.Status, body)
+}
+
+// dbErr 将数据库错误映射为统一错误，兼容 mssql/mysql/postgres 驱动
+// 生产模式下不向客户端暴露数据库原始错误文本
+func dbErr(err error) *ApiError {
+	e := errDatabase
+	var (
+		ms mssql.Error
+		my *mysql.MySQLError
+		pg *pq.Error
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		e = errTimeout
+	case errors.Is(err, errUnavailable):
+		e = errNoSource
+	case errors.As(err, &ms):
+		switch ms.Number {
+		case 2601, 2627:
+			e = errDuplicate
+		case 547:
+			if e = errConstraint; strings.Contains(ms.Message, "FOREIGN KEY") || strings.Contains(ms.Message, "REFERENCE") {
+				e = errForeignKey
+			}
+		case 515:
+			e = errConstraint
+		case 1205:
+			e = errDeadlock
+		case 1222:
+			e = errTimeout
+		}
+	case errors.As(err, &my):
+		switch my.Number {
+		case 1062:
+			e = errDuplicate
+		case 1451, 1452:
+			e = errForeignKey
+		case 1048, 3819:
+			e = errConstraint
+		case 1213:
+			e = errDeadlock
+		case 1205, 3024:
+			e = errTimeout
+		}
+	case errors.As(err, &pg):
+		switch pg.Code {
+		case "23505":
+			e = errDuplicate
+		case "23503":
+			e = errForeignKey
+		case "23502", "23514":
+			e = errConstraint
+		case "40P01", "40001":
+			e = errDeadlock
+		case "57014", "55P03":
+			e = errTimeout
+		}
+	}
+	if cfg.Production {
+		return e.with("", nil)
+	}
+	return e.with("", err)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// binder 收集模板渲染过程中绑定的参数，渲染时直接输出驱动的占位符：postgres为$1，mssql与mysql为?
+// mssql 以驱动名 mssql 打开，驱动只识别 ?/$N/:name 并按顺序转换为 @p1…，不识别 @p1
+// 渲染后不再整体替换 ?，字符串字面量与postgres的jsonb ? 运算符保持原样
+type binder struct {
+	driver string
+	args   []any
+}
+
+// identRe 合法的SQL标识符，允许中文列名及 schema.table 形式
+var identRe = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_]*(\.[\p{L}_][\p{L}\p{N}_]*)?$`)
+
+// likeEscaper 转义LIKE通配符，统一使用 ! 作为转义字符，三种数据库通用
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_", "[", "![")
+
+// tplFuncs 模板解析时使用的函数表，执行时按请求替换为绑定了参数收集器的版本
+var tplFuncs = sqlFuncs("", new(binder))
+
+// sqlFuncs 返回SQL模板函数库，driver用于按数据库转义字符串
+func sqlFuncs(driver string, b *binder) template.FuncMap {
+	return template.FuncMap{
+		// bind 绑定单个参数，输出占位符：{{bind .id}}
+		"bind": b.bind,
+		// in 将切片展开为绑定参数列表：ID IN {{in .ids}} → ID IN (?, ?, ?)
+		"in": b.in,
+		// quote 输出转义后的字符串字面量，nil输出NULL
+		"quote": func(v any) string { return quote(driver, v) },
+		// ident 校验标识符，可限定在白名单内：ORDER BY {{ident .sort "Name" "CreateTime"}}
+		"ident": ident,
+		// like 转义通配符并两端加 %，配合 ESCAPE '!' 使用：Name LIKE {{bind (like .name)}} ESCAPE '!'
+		"like": func(v any) string {
+			if empty(v) {
+				return ""
+			}
+			return "%" + likeEscaper.Replace(fmt.Sprint(v)) + "%"
+		},
+		// default 值为空时使用默认值：{{.size | default 20}}
+		"default": func(def, v any) any {
+			if empty(v) {
+				return def
+			}
+			return v
+		},
+		// coalesce 返回第一个非空值
+		"coalesce": func(vs ...any) any {
+			for _, v := range vs {
+				if !empty(v) {
+					return v
+				}
+			}
+			return nil
+		},
+		// now 当前时间，date 按Go时间格式输出：{{date "2006-01-02" now}}
+		"now":  time.Now,
+		"date": date,
+		// toInt 严格转换为整数，非数字时渲染失败
+		"toInt": func(v any) (int64, error) {
+			n, err := strconv.ParseInt(strings.TrimSpace(fmt.Sprint(v)), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("toInt: %q 不是整数", fmt.Sprint(v))
+			}
+			return n, nil
+		},
+		// json 输出值的JSON文本
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		// where/andWhere 按“条件, 值”成对传入，丢弃值为空的条件，其余以 AND 连接并绑定参数：
+		// {{where "Status = ?" .status "CustomerID = ?" .customer "ID IN ?" .ids}}
+		"where":    func(pairs ...any) (string, error) { return b.filters("WHERE ", pairs) },
+		"andWhere": func(pairs ...any) (string, error) { return b.filters("AND ", pairs) },
+	}
+}
+
+// bind 记录参数并返回驱动的占位符
+func (b *binder) bind(v any) string {
+	b.args = append(b.args, v)
+	if b.driver == "postgres" {
+		return "$" + strconv.Itoa(len(b.args))
+	}
+	return "?"
+}
+
+// in 将切片展开为 (?, ?, ...)，空切片输出 (NULL) 以不匹配任何行
+func (b *binder) in(v any) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "(" + b.bind(v) + ")"
+	}
+	if rv.Len() == 0 {
+		return "(NULL)"
+	}
+	marks := make([]string, rv.Len())
+	for i := range marks {
+		marks[i] = b.bind(rv.Index(i).Interface())
+	}
+	return "(" + strings.Join(marks, ", ") + ")"
+}
+
+// filters 生成条件子句，条件中的 ? 替换为占位符，遇到切片值时展开为IN列表
+func (b *binder) filters(prefix string, pairs []any) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("where: 条件与值须成对传入")
+	}
+	var conds []string
+	for i := 0; i < len(pairs); i += 2 {
+		cond, ok := pairs[i].(string)
+		if !ok {
+			return "", fmt.Errorf("where: 第%d个参数应为条件字符串", i+1)
+		}
+		if v := pairs[i+1]; !empty(v) {
+			k := reflect.ValueOf(v).Kind()
+			if k == reflect.Slice || k == reflect.Array {
+				cond = strings.Replace(cond, "?", b.in(v), 1)
+			} else {
+				cond = strings.Replace(cond, "?", b.bind(v), 1)
+			}
+			conds = append(conds, cond)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return prefix + strings.Join(conds, " AND "), nil
+}
+
+// quote 输出字符串字面量，MySQL额外转义反斜杠，mssql输出 N'...' 以免nvarchar列中的中文在非中文排序规则下丢失
+func quote(driver string, v any) string {
+	if v == nil {
+		return "NULL"
+	}
+	s := strings.ReplaceAll(fmt.Sprint(v), "'", "''")
+	switch driver {
+	case "mysql":
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	case "mssql":
+		return "N'" + s + "'"
+	}
+	return "'" + s + "'"
+}
+
+// ident 校验标识符合法，给定白名单时必须在白名单内
+func ident(v any, allowed ...string) (string, error) {
+	s := fmt.Sprint(v)
+	if !identRe.MatchString(s) {
+		return "", fmt.Errorf("ident: %q 不是合法的标识符", s)
+	}
+	if len(allowed) == 0 {
+		return s, nil
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, s) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("ident: %q 不在允许的列表中", s)
+}
+
+// date 按Go时间格式格式化时间，字符串按常见格式解析
+func date(layout string, v any) (string, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case string:
+		for _, l := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+			if p, err := time.ParseInLocation(l, t, time.Local); err == nil {
+				return p.Format(layout), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("date: 无法解析时间 %v", v)
+}
+
+// empty 判断值是否为空：nil、空字符串、空切片或空Map，0和false不视为空
+func empty(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() == 0
+	}
+	return false
+}
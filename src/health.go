@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin" // Web框架
+)
+
+// Health 定义就绪检查
+type Health struct {
+	Timeout int  `json:"timeout"` // 每项检查的超时（秒），默认3
+	Wechat  bool `json:"wechat"`  // 是否检查能否获取微信jsapi_ticket，获取失败时未就绪
+}
+
+// started 进程启动时间
+var started = time.Now()
+
+// Healthz 存活检查，进程能处理请求即返回200
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, Map{"status": 0})
+}
+
+// Readyz 就绪检查：未在退出、全部数据源主库可连接、路由注册表已加载、（可选）微信令牌有效，否则返回503及各项检查结果
+func Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), seconds(cfg.Health.Timeout))
+	defer cancel()
+
+	ready, checks := true, Map{}
+	check := func(name string, err error) {
+		if err != nil {
+			ready = false
+			checks[name] = err.Error()
+			return
+		}
+		checks[name] = "ok"
+	}
+	if draining.Load() {
+		check("server", errors.New("正在退出"))
+	}
+	for _, name := range sortedKeys(sources) {
+		s := sources[name]
+		err := errUnavailable
+		if db := s.db.Load(); db != nil && s.ready.Load() {
+			err = db.PingContext(ctx)
+		}
+		check("db:"+name, err)
+	}
+	var err error
+	if !registryLoaded.Load() {
+		err = errors.New("路由注册表未加载")
+	}
+	check("registry", err)
+	if cfg.Health.Wechat {
+		_, err := getJsapiTicket(ctx)
+		check("wechat", err)
+	}
+
+	if !ready {
+		Fail(c, errNoSource.with("服务未就绪", checks))
+		return
+	}
+	c.JSON(http.StatusOK, Map{"data": checks, "status": 0})
+}
+
+// Version 输出版本、提交、启动时间与各数据源的驱动
+func Version(c *gin.Context) {
+	drivers := make(Map, len(cfg.Datasources))
+	for name, ds := range cfg.Datasources {
+		drivers[name] = ds.Driver
+	}
+	c.JSON(http.StatusOK, Map{"data": Map{
+		"version": version,
+		"commit":  commit,
+		"started": started.Format(time.RFC3339),
+		"uptime":  int64(time.Since(started).Seconds()),
+		"go":      runtime.Version(),
+		"drivers": drivers,
+	}, "status": 0})
+}
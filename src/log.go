@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"         // Web框架
+	"go.opentelemetry.io/otel/trace"   // 链路API
+	"gopkg.in/natefinch/lumberjack.v2" // 按大小滚动的日志文件
+)
+
+// Log 定义结构化日志的级别、输出与脱敏字段
+type Log struct {
+	Level      string   `json:"level"`      // 日志级别：debug/info/warn/error，默认info；debug时访问日志附带脱敏后的参数与SQL
+	File       string   `json:"file"`       // 日志文件路径，为空时输出到标准输出
+	MaxSize    int      `json:"maxSize"`    // 单个日志文件上限（MB），超过后滚动，默认100
+	MaxBackups int      `json:"maxBackups"` // 保留的旧日志文件个数，默认10
+	MaxAge     int      `json:"maxAge"`     // 旧日志文件保留天数，默认30
+	Compress   bool     `json:"compress"`   // 是否gzip压缩旧日志文件
+	Redact     []string `json:"redact"`     // 脱敏的字段名，不区分大小写，默认 password/token/code/secret
+}
+
+// logLevels 支持的日志级别
+var logLevels = map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError}
+
+var (
+	redactKeys map[string]bool // 脱敏字段名（小写）
+
+	sqlLiteral = regexp.MustCompile(`'(?:[^']|'')*'|\b\d+(?:\.\d+)?\b`)
+	sqlList    = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	sqlSpace   = regexp.MustCompile(`\s*([^\w\s])\s*|\s+`)
+)
+
+// initLog 创建JSON格式的默认日志，标准库log与gin的输出一并写入同一目标
+func initLog() {
+	var w io.Writer = os.Stdout
+	if l := cfg.Log; l.File != "" {
+		w = &lumberjack.Logger{Filename: l.File, MaxSize: l.MaxSize, MaxBackups: l.MaxBackups, MaxAge: l.MaxAge, Compress: l.Compress, LocalTime: true}
+	}
+	redactKeys = make(map[string]bool)
+	for _, k := range cfg.Log.Redact {
+		redactKeys[strings.ToLower(k)] = true
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: logLevels[cfg.Log.Level],
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if redactKeys[strings.ToLower(a.Key)] {
+				return slog.String(a.Key, "***")
+			}
+			return a
+		},
+	})))
+	gin.DefaultWriter, gin.DefaultErrorWriter = w, w
+}
+
+// AccessLog 每个请求结束后输出一行访问日志：请求ID、路由、方法、用户、状态、耗时、行数与SQL指纹
+// 状态码 >= 500 记为error，>= 400 记为warn，其余为info
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status, level := c.Writer.Status(), slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("requestId", c.GetString("requestId")),
+			slog.String("route", routeLabel(c)),
+			slog.String("method", c.Request.Method),
+			slog.Int("status", status),
+			slog.Int64("durationMs", time.Since(start).Milliseconds()),
+		}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("traceId", sc.TraceID().String()))
+		}
+		if v, ok := c.Get("userID"); ok {
+			attrs = append(attrs, slog.Any("userID", v))
+		}
+		if v, ok := c.Get("rows"); ok {
+			attrs = append(attrs, slog.Any("rows", v))
+		}
+		if code := c.GetString("errorCode"); code != "" {
+			attrs = append(attrs, slog.String("errorCode", code))
+		}
+		sqlstr := c.GetString("sql")
+		if sqlstr != "" {
+			attrs = append(attrs, slog.String("fingerprint", fingerprint(sqlstr)))
+		}
+		if slog.Default().Enabled(c, slog.LevelDebug) {
+			if v, ok := c.Get("params"); ok {
+				attrs = append(attrs, slog.Any("params", redact(v)))
+			}
+			if sqlstr != "" {
+				attrs = append(attrs, slog.String("sql", sqlstr))
+			}
+		}
+		slog.LogAttrs(c, level, "request", attrs...)
+	}
+}
+
+// redact 复制参数并将脱敏字段的值替换为 ***，嵌套对象与数组递归处理
+func redact(v any) any {
+	switch v := v.(type) {
+	case Map:
+		return redactMap(v)
+	case map[string]any:
+		return redactMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = redact(e)
+		}
+		return out
+	}
+	return v
+}
+
+// redactMap 见 redact
+func redactMap(m map[string]any) Map {
+	out := make(Map, len(m))
+	for k, v := range m {
+		if redactKeys[strings.ToLower(k)] {
+			out[k] = "***"
+		} else {
+			out[k] = redact(v)
+		}
+	}
+	return out
+}
+
+// fingerprint 返回SQL指纹：字面量替换为?、IN列表合并、去除符号两侧空白并转小写后取哈希，同一模板渲染出的语句指纹相同
+func fingerprint(sqlstr string) string {
+	s := sqlList.ReplaceAllString(sqlLiteral.ReplaceAllString(sqlstr, "?"), "(?)")
+	s = strings.ToLower(strings.TrimSpace(sqlSpace.ReplaceAllStringFunc(s, func(m string) string {
+		if t := strings.TrimSpace(m); t != "" {
+			return t
+		}
+		return " "
+	})))
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:8])
+}
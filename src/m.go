@@ -1,14 +1,18 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -16,13 +20,10 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 
-	"github.com/gin-contrib/cors"  // 跨域资源共享中间件
 	"github.com/gin-gonic/gin"     // Web框架
 	"github.com/golang-jwt/jwt/v5" // JWT库
-	"github.com/jmoiron/sqlx"      // 增强的数据库操作包
 
 	// 注册数据库驱动，但不直接使用其中的函数
 	_ "github.com/denisenkom/go-mssqldb" // SQL Server驱动
@@ -35,11 +36,31 @@ type (
 	Map map[string]any
 	// Cfg 定义配置文件结构
 	Cfg struct {
-		Driver string `json:"driver"` // 数据库驱动类型：mssql/mysql/postgres
-		Dsn    string `json:"dsn"`    // 数据库连接字符串
+		Driver string `json:"driver"` // 数据库驱动类型：mssql/mysql/postgres，作为default数据源
+		Dsn    string `json:"dsn"`    // 数据库连接字符串，作为default数据源
 		Query  string `json:"query"`  // 用于获取SQL模板的查询语句
 		Api    string `json:"api"`    // API路由路径
 		Port   int    `json:"port"`   // 服务监听端口
+		Memo   string `json:"memo"`   // 备注，程序不使用
+
+		Datasources map[string]*DataSource `json:"datasources"` // 命名数据源，路由通过API表的数据源列选择
+		Meta        string                 `json:"meta"`        // 存放API表的元数据源名称，默认default
+		Pool        Pool                   `json:"pool"`        // 所有数据源默认的连接池与重连策略
+		Timeout     int                    `json:"timeout"`     // 默认查询超时（秒），路由可通过API表的超时列覆盖，默认30
+		Production  bool                   `json:"production"`  // 生产模式：5xx错误不向客户端返回详情（数据库原始错误、上游接口错误等），详情只记录在日志中
+		ParamOrder  []string               `json:"paramOrder"`  // 同名参数的来源优先级，可选 path/body/query/header，默认按此顺序
+		Headers     []string               `json:"headers"`     // 作为模板参数的请求头名称，如 X-Tenant-ID
+		Routes      string                 `json:"routes"`      // 列出全部路由的查询语句，返回 路由/方法/描述/鉴权/参数 等列，用于生成接口文档
+		Fragments   string                 `json:"fragments"`   // 查询公共模板片段的语句，返回 名称/模板 列，为空时不加载片段
+		Reload      int                    `json:"reload"`      // 路由注册表重建间隔（秒），重建时重新加载片段并清空路由缓存，默认60
+		Upload      Upload                 `json:"upload"`      // 文件上传的限制与存储后端
+		Log         Log                    `json:"log"`         // 结构化日志的级别、滚动文件与脱敏字段
+		Trace       Trace                  `json:"trace"`       // OpenTelemetry链路追踪
+		Health      Health                 `json:"health"`      // /readyz 就绪检查
+		Server      Server                 `json:"server"`      // 平滑退出与重启
+		TLS         TLS                    `json:"tls"`         // 内置HTTPS与mTLS
+		Cors        Cors                   `json:"cors"`        // 跨域策略，可按路由覆盖
+		Admin       Admin                  `json:"admin"`       // 路由管理接口
 
 		JWTSecret string `json:"jwtSecret"` // JWT签名密钥
 		JWTExpire int    `json:"jwtExpire"` // JWT过期时间（秒）
@@ -54,9 +75,9 @@ type (
 )
 
 var (
-	db      *sqlx.DB   // 数据库连接实例
-	cfg     = new(Cfg) // 配置实例
-	dbMutex sync.Mutex // 保护数据库连接操作的互斥锁
+	cfg     = new(Cfg)  // 配置实例
+	version = "dev"     // 版本号，编译时通过 -ldflags "-X main.version=..." 注入
+	commit  = "unknown" // 提交号，编译时通过 -ldflags "-X main.commit=..." 注入
 )
 
 // Claims 定义JWT的声明
@@ -100,6 +121,13 @@ func ParseToken(tokenString string) (*Claims, error) {
 	})
 
 	if err != nil {
+		reason := "invalid"
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			reason = "expired"
+		}
+		if tokenString != "" {
+			jwtFailures.WithLabelValues(reason).Inc()
+		}
 		return nil, err
 	}
 
@@ -123,8 +151,8 @@ func JWTAuth() gin.HandlerFunc {
 
 		// 检查token是否存在
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, Map{"status": 1, "message": "未提供授权令牌"})
-			c.Abort() // 只需要Abort，不需要return
+			jwtFailures.WithLabelValues("missing").Inc()
+			Fail(c, errUnauthorized.with("未提供授权令牌", nil))
 			return
 		}
 
@@ -136,8 +164,7 @@ func JWTAuth() gin.HandlerFunc {
 		// 解析和验证token
 		claims, err := ParseToken(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, Map{"status": 1, "message": "无效的授权令牌", "error": err.Error()})
-			c.Abort() // 只需要Abort，不需要return
+			Fail(c, errTokenInvalid.with("", err))
 			return
 		}
 
@@ -149,6 +176,19 @@ func JWTAuth() gin.HandlerFunc {
 	}
 }
 
+// RequestID 为每个请求分配请求ID，优先沿用上游传入的 X-Request-ID
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			id = randomHex(8)
+		}
+		c.Set("requestId", id)
+		c.Header("X-Request-ID", id)
+		c.Next()
+	}
+}
+
 // WechatResponse 微信登录接口返回数据结构
 type WechatResponse struct {
 	OpenID     string `json:"openid"`
@@ -159,20 +199,13 @@ type WechatResponse struct {
 }
 
 // 微信鉴权，通过code获取openid
-func GetWechatOpenID(code string) (*WechatResponse, error) {
+func GetWechatOpenID(ctx context.Context, code string) (*WechatResponse, error) {
 	// 构建请求URL
 	reqURL := fmt.Sprintf("%s?appid=%s&secret=%s&js_code=%s&grant_type=authorization_code",
 		cfg.WechatTokenUrl, cfg.WechatAppID, cfg.WechatSecret, code)
 
-	// 发起HTTP请求
-	resp, err := http.Get(reqURL)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// 读取响应
-	body, err := ioutil.ReadAll(resp.Body)
+	// 发起HTTP请求并读取响应
+	body, err := wechatGet(ctx, "jscode2session", reqURL)
 	if err != nil {
 		return nil, err
 	}
@@ -199,7 +232,7 @@ var (
 )
 
 // 获取jsapi_ticket
-func getJsapiTicket() (string, error) {
+func getJsapiTicket(ctx context.Context) (string, error) {
 	jsapiTicketLock.Lock()
 	defer jsapiTicketLock.Unlock()
 
@@ -210,13 +243,10 @@ func getJsapiTicket() (string, error) {
 
 	// 第一步：请求access_token
 	accessTokenUrl := fmt.Sprintf("%s?grant_type=client_credential&appid=%s&secret=%s", cfg.WechatAccessTokenUrl, cfg.WechatAppID, cfg.WechatSecret)
-	resp, err := http.Get(accessTokenUrl)
+	body, err := wechatGet(ctx, "token", accessTokenUrl)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	body, _ := ioutil.ReadAll(resp.Body)
 	var tokenResp struct {
 		AccessToken string `json:"access_token"`
 		ExpiresIn   int    `json:"expires_in"`
@@ -232,13 +262,10 @@ func getJsapiTicket() (string, error) {
 
 	// 第二步：请求jsapi_ticket
 	ticketUrl := fmt.Sprintf("%s?access_token=%s&type=jsapi", cfg.WechatTicketUrl, tokenResp.AccessToken)
-	resp2, err := http.Get(ticketUrl)
+	body2, err := wechatGet(ctx, "ticket", ticketUrl)
 	if err != nil {
 		return "", err
 	}
-	defer resp2.Body.Close()
-
-	body2, _ := ioutil.ReadAll(resp2.Body)
 	var ticketResp struct {
 		Ticket    string `json:"ticket"`
 		ExpiresIn int    `json:"expires_in"`
@@ -280,86 +307,77 @@ func generateWechatSignature(ticket, nonceStr string, timestamp int64, url strin
 
 // main 程序入口函数
 func main() {
-	// 读取与可执行文件同名的JSON配置文件
+	// 默认读取与可执行文件同名的JSON配置文件，可通过 -config 指定JSON/YAML/TOML文件
 	fp, fn := filepath.Split(os.Args[0])
-	b, err := ioutil.ReadFile(fp + strings.Replace(fn, ".exe", ".json", 1))
-	if err != nil {
-		log.Fatalf("无法读取配置文件: %v", err)
+	conf := flag.String("config", fp+strings.TrimSuffix(fn, ".exe")+".json", "配置文件路径(.json/.yaml/.yml/.toml)")
+	flag.Parse()
+
+	// 加载并校验配置，一次性报告全部错误
+	if err := loadConfig(*conf); err != nil {
+		log.Fatalf("配置错误:\n%v", err)
 	}
 
-	// 解析配置文件
-	if err := json.Unmarshal(b, &cfg); err != nil {
-		log.Fatalf("配置文件格式错误: %v", err)
+	// 初始化结构化日志，之后的日志均为JSON格式
+	initLog()
+
+	// 初始化链路追踪，未配置导出方式时为空实现
+	if err := initTrace(); err != nil {
+		slog.Error("链路追踪初始化失败", "error", err)
 	}
 
 	// 初始化数据库连接池
 	initDB()
 
+	// 构建路由注册表，加载公共模板片段
+	initRegistry()
+
+	// 初始化上传文件的存储后端
+	initUpload()
+
 	// 设置Gin为发布模式，减少日志输出
 	gin.SetMode(gin.ReleaseMode)
-	// 创建默认的Gin路由引擎，包含Logger和Recovery中间件
-	r := gin.Default()
+	// 创建Gin路由引擎，panic时同样输出统一错误信封
+	r := gin.New()
+	r.Use(Tracing(), AccessLog(), Metrics(), gin.CustomRecovery(func(c *gin.Context, _ any) { Fail(c, errInternal) }))
+	r.Use(RequestID())
+	r.NoRoute(func(c *gin.Context) { Fail(c, errNotFound) })
 
-	// 配置CORS中间件
+	// 配置CORS中间件，策略来自 cfg.Cors
 	r.Use(configureCORS())
 
-	// API路由组，根据鉴权需求配置
-	apiGroup := r.Group("/")
-
-	// 注册通用API处理函数，支持所有HTTP方法
-	apiGroup.Any(cfg.Api, Api)
+	// 存活、就绪与版本探针，供Caddy与监控系统探测
+	r.GET("/healthz", Healthz)
+	r.GET("/readyz", Readyz)
+	r.GET("/version", Version)
 
-	// 打印启动信息
-	log.Printf("【慧工厂】·【API启动:%v】·【by 一零院长】·【2023-present】·【v250425】", cfg.Port)
-	// 启动HTTP服务
-	r.Run(fmt.Sprint(":", cfg.Port))
-}
-
-// initDB 初始化并配置数据库连接池
-func initDB() {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+	// 连接池统计与Prometheus指标
+	r.GET("/stats", Stats)
+	r.GET("/metrics", MetricsHandler())
 
-	var err error
-	// 连接数据库
-	db, err = sqlx.Connect(cfg.Driver, cfg.Dsn)
-	if err != nil {
-		log.Fatalf("无法连接到数据库: %v", err)
+	// 路由管理接口，仅 admin.users 中的用户可访问
+	if len(cfg.Admin.Users) > 0 {
+		adminRoutes(r.Group("/admin"))
 	}
 
-	// 设置连接池参数
-	db.SetMaxOpenConns(25)                 // 最大打开连接数
-	db.SetMaxIdleConns(25)                 // 最大空闲连接数
-	db.SetConnMaxLifetime(5 * time.Minute) // 连接最大生命周期
+	// 接口文档：OpenAPI 3 与内嵌的Swagger UI
+	r.GET("/openapi.json", OpenAPI)
+	r.GET("/docs", Docs)
+	r.GET("/docs/:file", DocsAsset)
 
-	// 启动一个goroutine定期检查数据库连接
-	go func() {
-		for {
-			time.Sleep(1 * time.Minute)
-			if err := db.Ping(); err != nil {
-				log.Println("数据库连接丢失，尝试重连...")
-				reconnectDB()
-			}
-		}
-	}()
-}
-
-// reconnectDB 尝试重新连接数据库
-func reconnectDB() {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+	// API路由组，根据鉴权需求配置
+	apiGroup := r.Group("/")
 
-	var err error
-	// 最多尝试5次重连
-	for i := 0; i < 5; i++ {
-		db, err = sqlx.Connect(cfg.Driver, cfg.Dsn)
-		if err == nil {
-			log.Println("数据库重连成功")
-			return
-		}
-		time.Sleep(5 * time.Second)
+	// 注册通用API处理函数，支持所有HTTP方法
+	apiGroup.Any(cfg.Api, Api)
+	// 配置了版本表时，/api/v2/:a 形式的路径执行路由的固定版本
+	if cfg.Admin.Versions != "" {
+		apiGroup.Any(strings.Replace(cfg.Api, ":a", ":a/:pinned", 1), Api)
 	}
-	log.Fatalf("无法重连数据库: %v", err)
+
+	// 打印启动信息
+	slog.Info("【慧工厂】·【API启动】·【by 一零院长】·【2023-present】·【v250425】", "port", cfg.Port, "version", version)
+	// 启动HTTP服务，收到退出信号后平滑退出
+	serve(r)
 }
 
 // 验证密码 - ERP特殊密码验证
@@ -386,22 +404,47 @@ func Api(c *gin.Context) {
 	}
 
 	// 解析请求参数
-	param := ParseForm(c)
+	param, err := ParseForm(c)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		Fail(c, errTooLarge.with(fmt.Sprintf("请求体超过%dMB", tooLarge.Limit>>20), nil))
+		return
+	}
+	if err != nil {
+		Fail(c, errBadRequest.with("请求体解析失败", err))
+		return
+	}
+	// 管理员调试：?_dryrun=1 只渲染不执行，?_explain=1 返回执行计划
+	inspect := ""
+	for _, k := range []string{"_dryrun", "_explain"} {
+		if c.Query(k) == "1" {
+			inspect = k
+		}
+		delete(param, k)
+	}
+	if inspect != "" && !isAdmin(callerClaims(c)) {
+		Fail(c, errForbidden)
+		return
+	}
 
-	// 获取路由参数和HTTP方法
-	action := c.Param("a")     // 从路由路径中提取动作参数
-	method := c.Request.Method // 获取HTTP方法(GET/POST等)
+	// 获取路由参数和HTTP方法，固定版本请求如 /api/v2/orders
+	action, ver, ok := routeName(c) // 从路由路径中提取动作参数与版本号
+	method := c.Request.Method      // 获取HTTP方法(GET/POST等)
+	if !ok {
+		Fail(c, errNotFound)
+		return
+	}
 	// 特殊处理微信签名请求
 	if action == "wechat_signature" && method == "GET" {
 		url := c.Query("url")
 		if url == "" {
-			c.JSON(http.StatusBadRequest, Map{"status": 1, "message": "缺少url参数"})
+			Fail(c, errBadRequest.with("缺少url参数", nil))
 			return
 		}
 
-		ticket, err := getJsapiTicket()
+		ticket, err := getJsapiTicket(c.Request.Context())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, Map{"status": 1, "message": "获取jsapi_ticket失败", "error": err.Error()})
+			Fail(c, errUpstream.with("获取jsapi_ticket失败", err))
 			return
 		}
 
@@ -420,19 +463,39 @@ func Api(c *gin.Context) {
 		return
 	}
 
-	// 从数据库获取SQL模板和鉴权信息
-	var tmpStr string
-	var auth *int
-	row := db.QueryRow(cfg.Query, action, method)
-	err := row.Scan(&tmpStr, &auth)
+	// 从路由注册表获取已编译的SQL模板和鉴权信息
+	route, err := lookup(c.Request.Context(), action, method, ver)
+	var ae *ApiError
+	if errors.Is(err, sql.ErrNoRows) {
+		Fail(c, errNotFound)
+		return
+	}
+	// 路由不存在属于正常的404，只记录读取或编译失败
 	CatchErr("GET-API:", err)
+	if errors.As(err, &ae) {
+		Fail(c, ae)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, Map{"status": 1, "message": "API不存在"})
+		Fail(c, dbErr(err))
+		return
+	}
+	// 访问日志的路由名，只记录已存在的路由
+	c.Set("route", action)
+
+	// 获取路由使用的数据源
+	src, err := source(route.Ds)
+	if errors.Is(err, errUnavailable) {
+		Fail(c, errNoSource.with("数据源暂不可用", err))
+		return
+	}
+	if err != nil {
+		Fail(c, errInternal.with("数据源不存在", err))
 		return
 	}
 
 	// 检查是否需要鉴权
-	if auth != nil && *auth == 1 {
+	if route.Auth == 1 {
 		// 从请求头获取token
 		tokenString := c.GetHeader("Authorization")
 
@@ -443,7 +506,8 @@ func Api(c *gin.Context) {
 
 		// 检查token是否存在
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, Map{"status": 1, "message": "需要授权令牌"})
+			jwtFailures.WithLabelValues("missing").Inc()
+			Fail(c, errUnauthorized)
 			return
 		}
 
@@ -455,155 +519,170 @@ func Api(c *gin.Context) {
 		// 解析和验证token
 		claims, err := ParseToken(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, Map{"status": 1, "message": "无效的授权令牌", "error": err.Error()})
+			Fail(c, errTokenInvalid.with("", err))
 			return
 		}
 
 		// 将用户信息添加到参数中，以便SQL模板使用
 		param["userID"] = claims.UserID
 		param["userName"] = claims.UserName
+		c.Set("userID", claims.UserID)
+	}
+
+	// mTLS客户端证书身份只取自已验证的证书，不接受同名请求参数
+	if id := clientIdentity(c.Request); id != nil {
+		param["clientCert"] = id
+	} else {
+		delete(param, "clientCert")
 	}
 
-	buf := new(bytes.Buffer)
-	tmpsql := tmpStr
+	// 路由默认值：请求中未提供的参数使用API表默认值列中的值
+	for k, v := range route.Defaults {
+		if _, ok := param[k]; !ok {
+			param[k] = v
+		}
+	}
+
+	// 保存上传的文件，文件信息作为参数供模板插入附件记录；请求失败时删除已保存的文件
+	uploads, err := saveUploads(c.Request.Context(), c.Request.MultipartForm, route.Uploads, param)
+	if errors.As(err, &ae) {
+		Fail(c, ae)
+		return
+	}
+	defer func() {
+		if len(uploads) > 0 && (inspect != "" || c.Writer.Status() >= http.StatusBadRequest) {
+			removeUploads(uploads)
+		}
+	}()
+
+	// 微信登录：先通过code换取openid，供模板中的{{.openid}}使用
+	var wxResp *WechatResponse
+	if action == "wxlogin" && method == "POST" {
+		code, _ := param["code"].(string)
+		if code == "" {
+			Fail(c, errBadRequest.with("缺少微信授权码", nil))
+			return
+		}
+		if wxResp, err = GetWechatOpenID(c.Request.Context(), code); err != nil {
+			Fail(c, errUpstream.with("获取微信用户信息失败", err))
+			return
+		}
+		param["openid"] = wxResp.OpenID
+	}
 
-	// 解析SQL模板
-	tmp, e := template.New(action + method).Parse(tmpStr)
+	// 严格渲染模板，缺少参数时返回400并列出全部缺失参数，绝不执行未渲染的模板
+	c.Set("params", param)
+	_, span := tracer.Start(c.Request.Context(), "render")
+	tmpsql, args, e := render(route.tmpl, src.conf.Driver, param)
+	endSpan(span, e)
+	var missing missingParams
+	if errors.As(e, &missing) {
+		Fail(c, errParamMissing.with("缺少参数: "+strings.Join(missing, ", "), Map{"missing": missing}))
+		return
+	}
 	if e != nil {
-		// 模板解析失败，返回错误
-		c.JSON(http.StatusOK, Map{"data": e, "status": 1})
+		Fail(c, errBadRequest.with("SQL模板渲染失败", e))
 		return
 	}
-	// 将请求参数应用到模板
-	if e = tmp.Execute(buf, param); e == nil {
-		tmpsql = buf.String()
+	c.Set("sql", tmpsql)
+	if inspect == "_dryrun" {
+		c.JSON(http.StatusOK, Map{"data": Map{"sql": tmpsql, "args": args, "params": param}, "status": 0})
+		return
+	}
+
+	// 查询超时：路由超时列优先，否则使用默认超时；客户端断开时查询一并取消
+	wait := route.Timeout
+	if wait <= 0 {
+		wait = cfg.Timeout
 	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(wait)*time.Second)
+	defer cancel()
 
-	// 执行SQL查询
-	data := make([]Map, 0)
-	rows, err := db.Queryx(tmpsql)
+	// 执行SQL查询：查询模式走只读副本，执行与事务模式始终使用主库；_explain 只获取执行计划
+	var res *Result
+	if inspect == "_explain" {
+		res, err = src.explain(ctx, tmpsql, args...)
+	} else {
+		res, err = src.run(ctx, route, tmpsql, args...)
+	}
 	CatchErr("QUERY-ERR:", err)
+	// 只有执行失败时才判断超时，临近超时前已成功提交的写入仍按成功返回
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		Fail(c, errTimeout.with("", fmt.Sprintf("查询超过%d秒已取消", wait)))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Map{"status": 1, "message": "查询执行失败", "error": err.Error()})
+		Fail(c, dbErr(err))
 		return
 	}
-
-	// 处理查询结果
-	for rows.Next() {
-		mp := make(Map, 0)
-		err = rows.MapScan(mp)
-		if err == nil {
-			// 转换值的格式
-			for k, val := range mp {
-				mp[k] = Conv(val)
-			}
-			data = append(data, mp)
-		}
+	c.Set("rows", len(res.Rows))
+	if route.Result == "none" {
+		c.Set("rows", res.Affected)
+	}
+	if inspect == "_explain" {
+		c.JSON(http.StatusOK, Map{"data": Map{"sql": tmpsql, "args": args, "plan": res.Rows}, "status": 0})
+		return
 	}
 
-	// 处理微信登录请求
-	if action == "wxlogin" && method == "POST" {
-		code, hasCode := param["code"]
-		if hasCode {
-			// 获取微信OpenID
-			wxResp, err := GetWechatOpenID(code.(string))
-			if err != nil {
-				c.JSON(http.StatusOK, Map{
-					"status":  1,
-					"message": "获取微信用户信息失败",
-					"error":   err.Error(),
-				})
-				return
-			}
+	data := res.Rows
 
-			// 如果已经执行了SQL查询（通过模板中的{{.openid}}占位符）
-			// 则直接使用查询结果，否则重新执行查询
-			userData := data
-			// 如果SQL模板中有OpenID占位符但未能替换，这里需要重新查询
-			if strings.Contains(tmpsql, "{{.openid}}") {
-				// 替换模板中的{{.openid}}
-				newSql := strings.ReplaceAll(tmpsql, "{{.openid}}", wxResp.OpenID)
-
-				if newSql != tmpsql {
-					// SQL发生了变化，需要重新查询
-					rows, err := db.Queryx(newSql)
-					CatchErr("QUERY-ERR:", err)
-					if err != nil {
-						c.JSON(http.StatusInternalServerError, Map{"status": 1, "message": "查询执行失败", "error": err.Error()})
-						return
-					}
+	// 文件模式：返回二进制列或文件路径列指向的文件
+	if route.Mode == "file" {
+		serveFile(c, data)
+		return
+	}
 
-					// 清空原来的数据，填充新数据
-					userData = make([]Map, 0)
-					for rows.Next() {
-						mp := make(Map, 0)
-						err = rows.MapScan(mp)
-						if err == nil {
-							// 转换值的格式
-							for k, val := range mp {
-								mp[k] = Conv(val)
-							}
-							userData = append(userData, mp)
-						}
-					}
-				}
-			}
+	// 按路由结构分组、嵌套与重命名
+	if route.Shape != nil {
+		data = route.Shape.apply(data)
+	}
 
-			// 判断是否找到用户
-			if len(userData) > 0 {
-				// 提取用户信息
-				userID := 0
-				userName := ""
-				if id, ok := userData[0]["UserID"]; ok {
-					switch v := id.(type) {
-					case float64:
-						userID = int(v)
-					case int:
-						userID = v
-					case int64:
-						userID = int(v)
-					case string:
-						fmt.Sscanf(v, "%d", &userID)
-					}
+	// 处理微信登录请求
+	if wxResp != nil {
+		// 判断是否找到用户
+		if len(data) > 0 {
+			// 提取用户信息
+			userID := 0
+			userName := ""
+			if id, ok := data[0]["UserID"]; ok {
+				switch v := id.(type) {
+				case float64:
+					userID = int(v)
+				case int:
+					userID = v
+				case int64:
+					userID = int(v)
+				case string:
+					fmt.Sscanf(v, "%d", &userID)
 				}
-				if name, ok := userData[0]["UserName"]; ok {
-					if s, ok := name.(string); ok {
-						userName = s
-					}
+			}
+			if name, ok := data[0]["UserName"]; ok {
+				if s, ok := name.(string); ok {
+					userName = s
 				}
+			}
 
-				// 生成JWT令牌
-				token, err := GenerateToken(userID, userName)
-				if err == nil {
-					// 返回令牌
-					c.JSON(http.StatusOK, Map{
-						"status": 0,
-						"token":  token,
-						"openid": wxResp.OpenID,
-						"data":   userData,
-					})
-					return
-				} else {
-					c.JSON(http.StatusInternalServerError, Map{
-						"status":  1,
-						"message": "令牌生成失败",
-						"error":   err.Error(),
-					})
-					return
-				}
-			} else {
-				// 未找到用户，返回openid，前端可处理注册流程
+			// 生成JWT令牌
+			token, err := GenerateToken(userID, userName)
+			if err == nil {
+				// 返回令牌
 				c.JSON(http.StatusOK, Map{
-					"status":  2, // 未找到用户但openid有效
-					"openid":  wxResp.OpenID,
-					"message": "未绑定用户",
+					"status": 0,
+					"token":  token,
+					"openid": wxResp.OpenID,
+					"data":   data,
 				})
 				return
+			} else {
+				Fail(c, errInternal.with("令牌生成失败", err))
+				return
 			}
 		} else {
-			c.JSON(http.StatusBadRequest, Map{
-				"status":  1,
-				"message": "缺少微信授权码",
+			// 未找到用户，返回openid，前端可处理注册流程
+			c.JSON(http.StatusOK, Map{
+				"status":  2, // 未找到用户但openid有效
+				"openid":  wxResp.OpenID,
+				"message": "未绑定用户",
 			})
 			return
 		}
@@ -622,20 +701,19 @@ func Api(c *gin.Context) {
 				salt, hasSalt := data[0]["Salt"]
 
 				if hasDbPassword && hasSalt {
+					// 重复的键会解析为数组，登录名与密码必须是字符串
+					name, ok1 := loginName.(string)
+					pwd, ok2 := password.(string)
+					if !ok1 || !ok2 {
+						Fail(c, errBadRequest.with("登录名和密码必须是字符串", nil))
+						return
+					}
+					dbPwd, ok1 := dbPassword.(string)
+					dbSalt, ok2 := salt.(string)
 					// 验证密码
-					passwordValid := ValidatePassword(
-						loginName.(string),
-						password.(string),
-						dbPassword.(string),
-						salt.(string),
-					)
-
-					if !passwordValid {
+					if !ok1 || !ok2 || !ValidatePassword(name, pwd, dbPwd, dbSalt) {
 						// 密码验证失败
-						c.JSON(http.StatusUnauthorized, Map{
-							"status":  1,
-							"message": "用户名或密码错误",
-						})
+						Fail(c, errLoginFailed)
 						return
 					}
 
@@ -672,27 +750,38 @@ func Api(c *gin.Context) {
 						})
 						return
 					} else {
-						c.JSON(http.StatusInternalServerError, Map{
-							"status":  1,
-							"message": "令牌生成失败",
-							"error":   err.Error(),
-						})
+						Fail(c, errInternal.with("令牌生成失败", err))
 						return
 					}
 				}
 			}
 
 			// 如果代码执行到这里，说明登录失败
-			c.JSON(http.StatusUnauthorized, Map{
-				"status":  1,
-				"message": "用户名或密码错误",
-			})
+			Fail(c, errLoginFailed)
 			return
 		}
 	}
 
 	// 返回JSON格式的结果
-	c.JSON(http.StatusOK, Map{"data": data, "status": 0})
+	// 按结果模式输出：list数组，one单个对象，scalar首行首列，none受影响行数
+	switch route.Result {
+	case "one":
+		if len(data) == 0 {
+			Fail(c, errNotFound.with("记录不存在", nil))
+			return
+		}
+		c.JSON(http.StatusOK, Map{"data": data[0], "status": 0})
+	case "scalar":
+		var v any
+		if len(res.Rows) > 0 && len(res.Cols) > 0 {
+			v = res.Rows[0][res.Cols[0]]
+		}
+		c.JSON(http.StatusOK, Map{"data": v, "status": 0})
+	case "none":
+		c.JSON(http.StatusOK, Map{"affected": res.Affected, "status": 0})
+	default:
+		c.JSON(http.StatusOK, Map{"data": data, "status": 0})
+	}
 }
 
 // Conv 转换数据库查询结果中的值为更适合JSON格式的类型
@@ -710,40 +799,101 @@ func Conv(pval interface{}) interface{} {
 }
 
 // ParseForm 解析HTTP请求中的参数
-func ParseForm(c *gin.Context) Map {
-	c.Request.ParseForm() // 解析URL查询参数和表单数据
-	param := make(Map)
+// 同名参数按 cfg.ParamOrder 指定的来源优先级取值，默认 路径 > 请求体 > 查询串 > 请求头
+// 重复的键或 ids[]= 形式的键解析为切片，JSON请求体中的数组和对象原样保留
+// multipart 请求的文件保留在 c.Request.MultipartForm 中，由 saveUploads 保存
+func ParseForm(c *gin.Context) (Map, error) {
+	sources := make(map[string]Map, 4)
+
+	// 路径参数，不含路由名 a 与固定版本段 pinned，避免覆盖请求中的同名参数
+	sources["path"] = make(Map, len(c.Params))
+	for _, p := range c.Params {
+		if p.Key != "a" && p.Key != "pinned" {
+			sources["path"][p.Key] = p.Value
+		}
+	}
+
+	// 查询串，URL.Query 已完成解码，不再重复解码
+	sources["query"] = formValues(c.Request.URL.Query())
 
 	// 处理非GET和非DELETE请求的请求体
-	if c.Request.Method != "GET" && c.Request.Method != "DELETE" {
+	sources["body"] = make(Map)
+	if c.Request.Method == "GET" || c.Request.Method == "DELETE" {
+		// 无请求体
+	} else if c.ContentType() == "multipart/form-data" {
+		// 上传请求限制请求体大小，普通字段作为参数
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, uploadLimit())
+		if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+			return nil, err
+		}
+		sources["body"] = formValues(c.Request.MultipartForm.Value)
+	} else {
 		body, err := ioutil.ReadAll(c.Request.Body)
 		if err == nil {
 			c.Set("body", body) // 将原始请求体存储在上下文中
 		}
-		// 尝试将请求体解析为JSON
-		CatchErr("BIND-BODY", json.Unmarshal([]byte(body), &param))
+		if strings.HasPrefix(c.ContentType(), "application/x-www-form-urlencoded") {
+			form, err := url.ParseQuery(string(body))
+			CatchErr("BIND-FORM", err)
+			sources["body"] = formValues(form)
+		} else if len(body) > 0 {
+			// 尝试将请求体解析为JSON，顶层不是对象时整体作为 body 参数
+			var v any
+			if err := json.Unmarshal(body, &v); err != nil {
+				CatchErr("BIND-BODY", err)
+			} else if m, ok := v.(map[string]any); ok {
+				sources["body"] = m
+			} else {
+				sources["body"]["body"] = v
+			}
+		}
 	}
 
-	// 处理URL查询参数和表单数据
-	for k, v := range c.Request.Form {
-		param[k] = v[0] // 只取每个参数的第一个值
-		// 尝试URL解码
-		if pp, err := url.QueryUnescape(v[0]); err == nil {
-			param[k] = pp
+	// 请求头，仅 cfg.Headers 中列出的请求头作为参数
+	sources["header"] = make(Map, len(cfg.Headers))
+	for _, h := range cfg.Headers {
+		if v := c.GetHeader(h); v != "" {
+			sources["header"][h] = v
+		}
+	}
+
+	// 按优先级从低到高合并，高优先级覆盖低优先级
+	param := make(Map)
+	for i := len(cfg.ParamOrder) - 1; i >= 0; i-- {
+		for k, v := range sources[cfg.ParamOrder[i]] {
+			param[k] = v
 		}
 	}
+	return param, nil
+}
 
+// formValues 将查询串或表单值转换为参数：单值为字符串，重复的键或以 [] 结尾的键为切片
+func formValues(form url.Values) Map {
+	param := make(Map, len(form))
+	for k, v := range form {
+		if name := strings.TrimSuffix(k, "[]"); name != k || len(v) > 1 || param[name] != nil {
+			param[name] = append(toSlice(param[name]), v...)
+		} else {
+			param[name] = v[0]
+		}
+	}
 	return param
 }
 
+// toSlice 将已有的参数值转为字符串切片，用于合并 ids=1&ids[]=2 这类混合写法
+func toSlice(v any) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case string:
+		return []string{v}
+	}
+	return nil
+}
+
 // CatchErr 简单的错误处理函数，记录错误但不中断执行
 func CatchErr(desc string, err error) {
 	if err != nil {
-		log.Printf("%s %v", desc, err)
+		slog.Error(desc, "error", err)
 	}
 }
-
-// configureCORS 配置CORS中间件
-func configureCORS() gin.HandlerFunc {
-	return cors.Default()
-}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"                                // Web框架
+	"github.com/prometheus/client_golang/prometheus"          // Prometheus指标
+	"github.com/prometheus/client_golang/prometheus/promauto" // 自动注册指标
+	"github.com/prometheus/client_golang/prometheus/promhttp" // /metrics 输出
+)
+
+// 指标的路由标签只取API表中已存在的路由名或gin的路径模式，不随请求路径增长
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apigo_http_requests_total", Help: "HTTP请求数",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "apigo_http_request_duration_seconds", Help: "HTTP请求耗时", Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "apigo_db_query_duration_seconds", Help: "SQL执行耗时", Buckets: prometheus.DefBuckets,
+	}, []string{"source", "mode"})
+	registryReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apigo_registry_reloads_total", Help: "路由注册表重建次数",
+	}, []string{"result"})
+	wechatDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "apigo_wechat_request_duration_seconds", Help: "微信接口调用耗时", Buckets: prometheus.DefBuckets,
+	}, []string{"api"})
+	wechatErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apigo_wechat_errors_total", Help: "微信接口调用失败次数，含网络错误与errcode非0",
+	}, []string{"api"})
+	jwtFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "apigo_jwt_failures_total", Help: "JWT校验失败次数",
+	}, []string{"reason"})
+)
+
+// stdMethods 标准HTTP方法，其余方法的标签统一为OTHER
+var stdMethods = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "CONNECT": true, "OPTIONS": true, "TRACE": true}
+
+func init() {
+	prometheus.MustRegister(poolCollector{})
+}
+
+// Metrics 记录每个请求的次数与耗时
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		method := c.Request.Method
+		if !stdMethods[method] {
+			method = "OTHER"
+		}
+		labels := prometheus.Labels{"route": routeLabel(c), "method": method, "status": strconv.Itoa(c.Writer.Status())}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	}
+}
+
+// MetricsHandler 输出Prometheus格式的指标
+func MetricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// routeLabel 返回请求的路由名：API请求为API表中的路由名，其余为gin的路径模式，未匹配任何路由时为 unmatched
+func routeLabel(c *gin.Context) string {
+	if r := c.GetString("route"); r != "" {
+		return r
+	}
+	if p := c.FullPath(); p != "" {
+		return p
+	}
+	return "unmatched"
+}
+
+// poolCollector 在每次采集时读取全部数据源与副本的连接池统计，重连替换的连接池同样生效
+type poolCollector struct{}
+
+var (
+	poolLabels   = []string{"pool"}
+	poolOpen     = prometheus.NewDesc("apigo_db_pool_open_connections", "已打开的连接数", poolLabels, nil)
+	poolInUse    = prometheus.NewDesc("apigo_db_pool_in_use_connections", "使用中的连接数", poolLabels, nil)
+	poolIdle     = prometheus.NewDesc("apigo_db_pool_idle_connections", "空闲连接数", poolLabels, nil)
+	poolMaxOpen  = prometheus.NewDesc("apigo_db_pool_max_open_connections", "最大连接数", poolLabels, nil)
+	poolWaits    = prometheus.NewDesc("apigo_db_pool_wait_total", "等待连接的次数", poolLabels, nil)
+	poolWaitTime = prometheus.NewDesc("apigo_db_pool_wait_seconds_total", "等待连接的总时间", poolLabels, nil)
+	poolUp       = prometheus.NewDesc("apigo_db_pool_up", "数据源或副本是否可用", poolLabels, nil)
+)
+
+// Describe 实现 prometheus.Collector
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{poolOpen, poolInUse, poolIdle, poolMaxOpen, poolWaits, poolWaitTime, poolUp} {
+		ch <- d
+	}
+}
+
+// Collect 实现 prometheus.Collector，副本的pool标签为 数据源#序号
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	emit := func(name string, up bool, st sql.DBStats) {
+		gauge := func(d *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, name)
+		}
+		gauge(poolOpen, float64(st.OpenConnections))
+		gauge(poolInUse, float64(st.InUse))
+		gauge(poolIdle, float64(st.Idle))
+		gauge(poolMaxOpen, float64(st.MaxOpenConnections))
+		gauge(poolUp, map[bool]float64{true: 1}[up])
+		ch <- prometheus.MustNewConstMetric(poolWaits, prometheus.CounterValue, float64(st.WaitCount), name)
+		ch <- prometheus.MustNewConstMetric(poolWaitTime, prometheus.CounterValue, st.WaitDuration.Seconds(), name)
+	}
+	for _, name := range sortedKeys(sources) {
+		s := sources[name]
+		if db := s.db.Load(); db != nil {
+			emit(name, s.ready.Load(), db.Stats())
+		}
+		for _, r := range s.replicas {
+			if r.db != nil {
+				emit(r.name, r.healthy.Load(), r.db.Stats())
+			}
+		}
+	}
+}
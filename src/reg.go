@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute" // 链路属性
+	"go.opentelemetry.io/otel/trace"     // 链路API
+)
+
+// Registry 是路由注册表：持有已解析的公共片段，并缓存已编译的路由
+// 注册表按 cfg.Reload 定期整体重建，重建时编译 cfg.Routes 列出的全部路由，其余路由在首次请求时编译
+type Registry struct {
+	base   *template.Template // 已解析全部公共片段的模板集
+	mu     sync.RWMutex
+	routes map[string]*Route // 已编译的路由，键为 "方法 路由"
+	failed map[string]string // 构建时编译失败的路由及原因，键同 routes
+}
+
+var (
+	registry       atomic.Pointer[Registry] // 当前生效的路由注册表，重建时原子替换
+	registryLoaded atomic.Bool              // 注册表是否至少成功构建过一次，供就绪检查
+)
+
+// initRegistry 构建路由注册表，并启动定期重建
+func initRegistry() {
+	reloadRegistry()
+	go func() {
+		for {
+			time.Sleep(seconds(cfg.Reload))
+			reloadRegistry()
+		}
+	}()
+}
+
+// reloadRegistry 重建路由注册表，失败时保留原注册表
+func reloadRegistry() error {
+	reg, err := buildRegistry(context.Background())
+	if err != nil {
+		registryReloads.WithLabelValues("error").Inc()
+		slog.Error("路由注册表构建失败", "error", err)
+		if registry.Load() == nil {
+			registry.Store(&Registry{base: template.New("").Option("missingkey=error").Funcs(tplFuncs), routes: map[string]*Route{}})
+		}
+		return err
+	}
+	registry.Store(reg)
+	registryLoaded.Store(true)
+	registryReloads.WithLabelValues("ok").Inc()
+	for _, key := range sortedKeys(reg.failed) {
+		slog.Error("路由编译失败", "route", key, "error", reg.failed[key])
+	}
+	return nil
+}
+
+// buildRegistry 从元数据源加载公共片段，检查片段引用完整且无循环，再编译 cfg.Routes 列出的全部路由
+func buildRegistry(ctx context.Context) (*Registry, error) {
+	base := template.New("").Option("missingkey=error").Funcs(tplFuncs)
+	frags, err := loadFragments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(frags) {
+		if _, err := base.New(name).Parse(frags[name]); err != nil {
+			return nil, fmt.Errorf("片段[%s]解析失败: %w", name, err)
+		}
+	}
+	for _, name := range sortedKeys(frags) {
+		if err := checkRefs(base, name, nil); err != nil {
+			return nil, err
+		}
+	}
+	reg := &Registry{base: base, routes: map[string]*Route{}}
+	if reg.failed, err = reg.compileRoutes(ctx); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// compileRoutes 逐个读取并编译 cfg.Routes 列出的路由，存入缓存，返回编译失败的路由及原因；停用的路由跳过
+func (reg *Registry) compileRoutes(ctx context.Context) (map[string]string, error) {
+	failed := make(map[string]string)
+	if cfg.Routes == "" {
+		return failed, nil
+	}
+	meta, err := source(cfg.Meta)
+	if err != nil {
+		return nil, err
+	}
+	res, err := queryRows(ctx, meta.db.Load(), true, cfg.Routes)
+	if err != nil {
+		return nil, fmt.Errorf("路由列表查询失败: %w", err)
+	}
+	for _, row := range res.Rows {
+		name, method := rowText(row, "路由"), strings.ToUpper(rowText(row, "方法"))
+		if name == "" || !httpMethods[method] {
+			continue
+		}
+		key := method + " " + name
+		r, err := getRoute(ctx, name, method)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err == nil {
+			err = reg.compile(key, r)
+		}
+		var ae *ApiError
+		switch {
+		case errors.As(err, &ae) && ae.Details != nil:
+			failed[key] = fmt.Sprintf("%s: %v", ae.Message, ae.Details)
+		case err != nil:
+			failed[key] = err.Error()
+		default:
+			reg.routes[key] = r
+		}
+	}
+	return failed, nil
+}
+
+// loadFragments 执行 cfg.Fragments 查询公共片段，按列名读取 名称/模板，未命名时取首列与末列
+func loadFragments(ctx context.Context) (map[string]string, error) {
+	frags := make(map[string]string)
+	if cfg.Fragments == "" {
+		return frags, nil
+	}
+	meta, err := source(cfg.Meta)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := meta.db.Load().QueryxContext(ctx, cfg.Fragments)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		vals, err := rows.SliceScan()
+		if err != nil {
+			return nil, err
+		}
+		name, text := fmt.Sprint(Conv(vals[0])), fmt.Sprint(Conv(vals[len(vals)-1]))
+		for i, col := range cols {
+			switch col {
+			case "名称":
+				name = fmt.Sprint(Conv(vals[i]))
+			case "模板":
+				text = fmt.Sprint(Conv(vals[i]))
+			}
+		}
+		frags[name] = text
+	}
+	return frags, rows.Err()
+}
+
+// lookup 从注册表获取已编译的路由，未缓存时从元数据源读取并编译；ver > 0 时读取版本表中的固定版本
+// 注册表重建时当前路由与固定版本的缓存一并清空，停用或删除路由后固定版本随之失效
+func lookup(ctx context.Context, action, method string, ver int) (*Route, error) {
+	reg, key := registry.Load(), method+" "+action
+	if ver > 0 {
+		key += "@" + strconv.Itoa(ver)
+	}
+	ctx, span := tracer.Start(ctx, "lookup", trace.WithAttributes(attribute.String("route.key", key)))
+	reg.mu.RLock()
+	r := reg.routes[key]
+	reg.mu.RUnlock()
+	span.SetAttributes(attribute.Bool("cache.hit", r != nil))
+	if r != nil {
+		span.End()
+		return r, nil
+	}
+
+	var err error
+	defer func() { endSpan(span, err) }()
+	if ver > 0 {
+		// 固定版本仅在当前路由存在且启用时提供，鉴权取当前定义与历史版本中更严格者
+		var cur *Route
+		if cur, err = lookup(ctx, action, method, 0); err != nil {
+			return nil, err
+		}
+		if r, err = getVersion(ctx, action, method, ver); err == nil {
+			r.Auth = max(r.Auth, cur.Auth)
+		}
+	} else {
+		r, err = getRoute(ctx, action, method)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = reg.compile(key, r); err != nil {
+		return nil, err
+	}
+	reg.mu.Lock()
+	reg.routes[key] = r
+	reg.mu.Unlock()
+	return r, nil
+}
+
+// compile 在公共片段模板集的副本中解析路由模板，并检查引用的片段
+func (reg *Registry) compile(name string, r *Route) error {
+	set, err := reg.base.Clone()
+	if err != nil {
+		return errTemplate.with("", err)
+	}
+	if r.tmpl, err = set.New(name).Parse(r.Tmpl); err != nil {
+		return errTemplate.with("", err)
+	}
+	if err := checkRefs(set, name, nil); err != nil {
+		return errTemplate.with("", err)
+	}
+	return nil
+}
+
+// checkRefs 深度优先检查模板引用的片段均存在且无循环引用，path为当前引用链
+func checkRefs(set *template.Template, name string, path []string) error {
+	for i, p := range path {
+		if p == name {
+			return fmt.Errorf("片段循环引用: %s", strings.Join(append(path[i:], name), " → "))
+		}
+	}
+	t := set.Lookup(name)
+	if t == nil || t.Tree == nil {
+		return fmt.Errorf("片段[%s]不存在，引用链: %s", name, strings.Join(path, " → "))
+	}
+	path = append(path[:len(path):len(path)], name)
+	for _, ref := range templateRefs(t.Root, nil) {
+		if err := checkRefs(set, ref, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateRefs 收集模板中 {{template "名称"}} 引用的片段名称
+func templateRefs(n parse.Node, refs []string) []string {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, c := range n.Nodes {
+				refs = templateRefs(c, refs)
+			}
+		}
+	case *parse.IfNode:
+		refs = templateRefs(n.ElseList, templateRefs(n.List, refs))
+	case *parse.RangeNode:
+		refs = templateRefs(n.ElseList, templateRefs(n.List, refs))
+	case *parse.WithNode:
+		refs = templateRefs(n.ElseList, templateRefs(n.List, refs))
+	case *parse.TemplateNode:
+		refs = append(refs, n.Name)
+	}
+	return refs
+}
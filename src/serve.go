@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Server 定义HTTP服务的退出与重启方式
+type Server struct {
+	Drain     int  `json:"drain"`     // 退出时等待进行中请求完成的最长时间（秒），默认30，超时后强制断开
+	PreStop   int  `json:"preStop"`   // 收到退出信号后/readyz先返回未就绪并继续服务的秒数，使负载均衡摘除实例，默认0
+	Ready     int  `json:"ready"`     // 交接套接字时等待新进程就绪的最长时间（秒），默认60
+	ReusePort bool `json:"reusePort"` // 以SO_REUSEPORT监听，新旧进程可同时监听同一端口，仅Linux
+}
+
+// draining 正在退出，/readyz 返回未就绪；preStop 期间仍继续服务，使负载均衡有时间摘除实例
+var draining atomic.Bool
+
+// serve 启动HTTP服务并等待退出信号：/readyz 返回未就绪并等待 preStop 秒，停止接受新连接，
+// 在 drain 秒内等待进行中的请求与事务完成，再关闭连接池并刷新未导出的链路；
+// Linux下收到 SIGUSR2 时先将监听套接字交给新进程，新进程就绪后再退出，新进程失败时继续服务
+func serve(h http.Handler) {
+	srv := &http.Server{Addr: fmt.Sprint(":", cfg.Port), Handler: h}
+	ln, err := listen(srv.Addr)
+	if err != nil {
+		slog.Error("无法监听端口", "port", cfg.Port, "error", err)
+		os.Exit(1)
+	}
+	// 配置证书时以HTTPS提供服务，ServeTLS同时启用HTTP/2
+	serveFn := func() error { return srv.Serve(ln) }
+	if cfg.TLS.Cert != "" {
+		if srv.TLSConfig, err = initTLS(); err != nil {
+			slog.Error("HTTPS启动失败", "error", err)
+			os.Exit(1)
+		}
+		serveFn = func() error { return srv.ServeTLS(ln, "", "") }
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- serveFn() }()
+	notifyReady()
+
+	sig := make(chan os.Signal, 1)
+	signals := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	if handoffSignal != nil {
+		signals = append(signals, handoffSignal)
+	}
+	signal.Notify(sig, signals...)
+	var preStop time.Duration
+wait:
+	for {
+		select {
+		case err := <-errc:
+			slog.Error("HTTP服务异常退出", "error", err)
+			break wait
+		case s := <-sig:
+			if s == handoffSignal {
+				if err := handoff(ln); err != nil {
+					slog.Error("监听套接字交接失败，继续运行", "error", err)
+					continue
+				}
+				slog.Info("新进程已接管监听套接字")
+			} else {
+				preStop = seconds(cfg.Server.PreStop)
+			}
+			slog.Info("收到退出信号，等待进行中的请求完成", "signal", s.String(), "drain", cfg.Server.Drain)
+			break wait
+		}
+	}
+	signal.Stop(sig)
+	shutdown(srv, preStop)
+}
+
+// notifyReady 由交接启动的新进程在开始服务后通知旧进程：环境变量 READY_FD 为旧进程传入的管道
+func notifyReady() {
+	fd, err := strconv.Atoi(os.Getenv("READY_FD"))
+	if err != nil {
+		return
+	}
+	os.Unsetenv("READY_FD")
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// listen 监听服务端口；环境变量 LISTEN_FDS=1 时沿用父进程交接（或systemd传入）的3号描述符
+func listen(addr string) (net.Listener, error) {
+	if os.Getenv("LISTEN_FDS") == "1" {
+		os.Unsetenv("LISTEN_FDS")
+		f := os.NewFile(3, "listener")
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	var lc net.ListenConfig
+	if cfg.Server.ReusePort {
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// shutdown 先在 preStop 内报告未就绪并继续服务，再停止接受新连接并等待进行中的请求，超时后强制断开，随后关闭连接池与链路导出
+func shutdown(srv *http.Server, preStop time.Duration) {
+	draining.Store(true)
+	if preStop > 0 {
+		slog.Info("已报告未就绪，等待负载均衡摘除", "preStop", preStop.Seconds())
+		time.Sleep(preStop)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), seconds(cfg.Server.Drain))
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("等待进行中的请求超时，强制断开", "error", err)
+		srv.Close()
+	}
+	closeDB()
+	if tracerProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			slog.Warn("链路导出刷新失败", "error", err)
+		}
+	}
+	slog.Info("服务已退出")
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix" // SO_REUSEPORT
+)
+
+// handoffSignal 交接监听套接字并平滑重启的信号
+var handoffSignal os.Signal = syscall.SIGUSR2
+
+// exe 启动时的可执行文件路径，替换二进制后仍指向原路径
+var exe, _ = os.Executable()
+
+// reusePort 为监听套接字设置 SO_REUSEPORT
+func reusePort(network, address string, c syscall.RawConn) (err error) {
+	cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// handoff 以相同参数启动新进程，并通过3号描述符传入监听套接字；新进程在初始化期间到达的连接排队等待，不会被拒绝
+// 新进程开始服务后通过4号描述符的管道报告就绪；初始化失败退出或超时未就绪时终止新进程并返回错误，旧进程继续服务
+func handoff(ln net.Listener) error {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return errors.New("监听套接字不支持交接")
+	}
+	f, err := tl.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{f, w}
+	cmd.Env = append(os.Environ(), "LISTEN_FDS=1", "READY_FD=4")
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	r.SetReadDeadline(time.Now().Add(seconds(cfg.Server.Ready)))
+	if _, err = r.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("新进程未就绪: %w", err)
+	}
+	return nil
+}
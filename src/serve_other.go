@@ -0,0 +1,26 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// handoffSignal 非Linux平台不支持交接监听套接字
+var handoffSignal os.Signal
+
+// errNotLinux 仅Linux支持的功能
+var errNotLinux = errors.New("仅Linux支持")
+
+// reusePort 非Linux平台不支持 SO_REUSEPORT，配置校验已拒绝 reusePort
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errNotLinux
+}
+
+// handoff 非Linux平台不支持交接监听套接字
+func handoff(ln net.Listener) error {
+	return errNotLinux
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Shape 描述路由结果的结构，对应API表的结构列（JSON）：
+// 按键列分组，子级列嵌套为命名数组，列可重命名，含点号的列名展开为嵌套对象
+//
+//	{"key": ["OrderID"], "rename": {"CustomerName": "customer.name"},
+//	 "children": {"lines": {"key": ["LineID"], "cols": ["LineID", "Product", "Qty"]}}}
+type Shape struct {
+	Key      []string          `json:"key"`      // 分组键列，为空时每行单独成组
+	Cols     []string          `json:"cols"`     // 本级输出的列，为空时为未被子级使用的全部列；子级必须指定
+	Rename   map[string]string `json:"rename"`   // 列重命名，新名称可含点号
+	Children map[string]*Shape `json:"children"` // 子数组名称 → 子级结构
+}
+
+// check 校验结构定义，子级必须指定输出列
+func (sh *Shape) check(path string) error {
+	for name, child := range sh.Children {
+		if child == nil || len(child.Cols) == 0 {
+			return fmt.Errorf("结构%s.children.%s: 子级必须指定cols", path, name)
+		}
+		if err := child.check(path + ".children." + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply 按结构整形查询结果，分组保持首次出现的顺序
+func (sh *Shape) apply(rows []Map) []Map {
+	var (
+		order  []string
+		groups = make(map[string][]Map)
+	)
+	for i, row := range rows {
+		k := fmt.Sprint(i)
+		if len(sh.Key) > 0 {
+			k = rowKey(row, sh.Key)
+		}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], row)
+	}
+
+	used := sh.childCols()
+	data := make([]Map, 0, len(order))
+	for _, k := range order {
+		first, obj := groups[k][0], make(Map)
+		if len(sh.Cols) > 0 {
+			for _, c := range sh.Cols {
+				obj[c] = first[c]
+			}
+		} else {
+			for c, v := range first {
+				if !used[c] {
+					obj[c] = v
+				}
+			}
+		}
+		for name, child := range sh.Children {
+			// 左连接没有子行时子级列全部为空，不输出空元素
+			var sub []Map
+			for _, row := range groups[k] {
+				if !allNil(row, child.Cols) {
+					sub = append(sub, row)
+				}
+			}
+			obj[name] = child.apply(sub)
+		}
+		data = append(data, nestKeys(sh.rename(obj)))
+	}
+	return data
+}
+
+// childCols 返回全部子级使用的列
+func (sh *Shape) childCols() map[string]bool {
+	used := make(map[string]bool)
+	for _, child := range sh.Children {
+		for _, c := range child.Cols {
+			used[c] = true
+		}
+		for c := range child.childCols() {
+			used[c] = true
+		}
+	}
+	return used
+}
+
+// rename 按重命名规则替换列名
+func (sh *Shape) rename(obj Map) Map {
+	for from, to := range sh.Rename {
+		if v, ok := obj[from]; ok {
+			delete(obj, from)
+			obj[to] = v
+		}
+	}
+	return obj
+}
+
+// nestKeys 将 customer.name 形式的列名展开为嵌套对象，与已有的非对象值冲突时保留原列名
+func nestKeys(obj Map) Map {
+	for k, v := range obj {
+		if !strings.Contains(k, ".") {
+			continue
+		}
+		parts, m := strings.Split(k, "."), obj
+		for _, p := range parts[:len(parts)-1] {
+			next, ok := m[p].(Map)
+			if !ok {
+				if m[p] != nil {
+					m = nil
+					break
+				}
+				next = make(Map)
+				m[p] = next
+			}
+			m = next
+		}
+		if m != nil {
+			delete(obj, k)
+			m[parts[len(parts)-1]] = v
+		}
+	}
+	return obj
+}
+
+// rowKey 以键列的值生成分组键
+func rowKey(row Map, key []string) string {
+	vals := make([]string, len(key))
+	for i, c := range key {
+		vals[i] = fmt.Sprint(row[c])
+	}
+	return strings.Join(vals, "\x00")
+}
+
+// allNil 判断给定列是否全部为空，查询结果中的NULL已转换为空字符串
+func allNil(row Map, cols []string) bool {
+	for _, c := range cols {
+		if row[c] != nil && row[c] != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// TLS 定义内置HTTPS服务，配置证书后启用，同时启用HTTP/2
+type TLS struct {
+	Cert       string `json:"cert"`       // 证书文件（PEM，可含中间证书链）
+	Key        string `json:"key"`        // 私钥文件（PEM）
+	MinVersion string `json:"minVersion"` // 最低TLS版本：1.2/1.3，默认1.2
+	ClientCA   string `json:"clientCA"`   // 客户端CA证书文件（PEM），配置后启用mTLS
+	ClientAuth string `json:"clientAuth"` // 客户端证书要求：optional提供时校验(默认)/require必须提供
+	Watch      int    `json:"watch"`      // 检查证书文件变化的间隔（秒），默认10，变化后重新加载
+}
+
+// tlsVersions 支持的最低TLS版本
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// tlsCurrent 当前生效的证书与客户端CA，重新加载时原子替换，已建立的连接不受影响
+var tlsCurrent atomic.Pointer[tls.Config]
+
+// initTLS 加载证书并启动文件变化检查，返回的配置在每次握手时取当前证书
+func initTLS() (*tls.Config, error) {
+	conf, err := loadTLS()
+	if err != nil {
+		return nil, err
+	}
+	tlsCurrent.Store(conf)
+	go watchTLS()
+	return &tls.Config{
+		MinVersion:         conf.MinVersion,
+		GetCertificate:     func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &tlsCurrent.Load().Certificates[0], nil },
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) { return tlsCurrent.Load(), nil },
+	}, nil
+}
+
+// loadTLS 读取证书、私钥与客户端CA，生成握手使用的配置
+func loadTLS() (*tls.Config, error) {
+	t := cfg.TLS
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, fmt.Errorf("证书加载失败: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tlsVersions[t.MinVersion],
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if t.ClientCA != "" {
+		pem, err := os.ReadFile(t.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("客户端CA加载失败: %w", err)
+		}
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("客户端CA加载失败: 文件中没有有效的PEM证书")
+		}
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+		if t.ClientAuth == "require" {
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return conf, nil
+}
+
+// watchTLS 定期检查证书、私钥与客户端CA的修改时间，变化后重新加载；加载失败时保留原证书
+func watchTLS() {
+	last := tlsModTime()
+	for {
+		time.Sleep(seconds(cfg.TLS.Watch))
+		mod := tlsModTime()
+		if mod.Equal(last) {
+			continue
+		}
+		conf, err := loadTLS()
+		if err != nil {
+			slog.Error("证书重新加载失败，继续使用原证书", "error", err)
+			continue
+		}
+		last = mod
+		tlsCurrent.Store(conf)
+		slog.Info("证书已重新加载")
+	}
+}
+
+// tlsModTime 返回证书相关文件中最新的修改时间
+func tlsModTime() (mod time.Time) {
+	for _, f := range []string{cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.ClientCA} {
+		if fi, err := os.Stat(f); f != "" && err == nil && fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	return
+}
+
+// clientIdentity 返回已验证的客户端证书身份，供模板中的{{.clientCert.cn}}等使用；未提供证书时返回nil
+func clientIdentity(r *http.Request) Map {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	return Map{
+		"cn":     leaf.Subject.CommonName,
+		"ou":     leaf.Subject.OrganizationalUnit,
+		"o":      leaf.Subject.Organization,
+		"dns":    leaf.DNSNames,
+		"email":  leaf.EmailAddresses,
+		"serial": leaf.SerialNumber.Text(16),
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// missingKey 匹配 missingkey=error 模式下缺少参数的错误信息
+var missingKey = regexp.MustCompile(`map has no entry for key "([^"]+)"`)
+
+// missingParams 模板渲染时缺少的参数名
+type missingParams []string
+
+// Error 实现error接口
+func (m missingParams) Error() string {
+	return "缺少参数: " + strings.Join(m, ", ")
+}
+
+// optionalFuncs 参数可缺省的函数，作为其参数的字段缺失时视为空值而非错误
+var optionalFuncs = map[string]bool{
+	"and": true, "or": true, "not": true,
+	"default": true, "coalesce": true, "where": true, "andWhere": true,
+}
+
+// noValue 空值被直接输出时 text/template 写入的文本
+const noValue = "<no value>"
+
+// render 严格渲染SQL模板，返回SQL与按顺序绑定的参数，driver用于生成占位符与字符串转义
+// 仅在 if/with/range 条件或可缺省函数中引用的参数是可选的，其余参数缺失时
+// 补空值继续渲染，以便一次性收集全部缺失参数后返回 missingParams；
+// 可选参数缺失却在条件之外被直接输出时（输出含 <no value>），同样作为缺失参数返回
+func render(tmp *template.Template, driver string, param Map) (string, []any, error) {
+	// 克隆后替换函数表，绑定参数收集器互不干扰，已解析的模板可并发复用
+	tmp, err := tmp.Clone()
+	if err != nil {
+		return "", nil, err
+	}
+	data, printed := make(Map, len(param)), make(map[string]bool)
+	for k := range optionalFields(tmp, tmp.Root, nil, printed) {
+		data[k] = nil
+	}
+	for k, v := range param {
+		data[k] = v
+	}
+	var missing missingParams
+	for {
+		b, buf := &binder{driver: driver}, new(bytes.Buffer)
+		err := tmp.Funcs(sqlFuncs(driver, b)).Execute(buf, data)
+		if err == nil {
+			if !strings.Contains(buf.String(), noValue) {
+				if len(missing) > 0 {
+					return "", nil, missing
+				}
+				return buf.String(), b.args, nil
+			}
+			for _, k := range sortedKeys(printed) {
+				if _, ok := param[k]; data[k] == nil && !ok && !slices.Contains(missing, k) {
+					missing = append(missing, k)
+				}
+			}
+			if len(missing) > 0 {
+				return "", nil, missing
+			}
+			return "", nil, fmt.Errorf("模板输出了空值 %s", noValue)
+		}
+		// 只处理顶层参数缺失，嵌套字段缺失或其他错误直接返回
+		if m := missingKey.FindStringSubmatch(err.Error()); m != nil {
+			if _, ok := data[m[1]]; !ok {
+				missing = append(missing, m[1])
+				data[m[1]] = ""
+				continue
+			}
+		}
+		// 已有缺失参数时，补空值引起的后续错误以缺失参数为准
+		if len(missing) > 0 {
+			return "", nil, missing
+		}
+		return "", nil, err
+	}
+}
+
+// optionalFields 收集模板中作为条件或可缺省函数参数引用的顶层字段，printed 收集被直接输出的顶层字段
+// with/range 内部的点已改变，不再向下查找；以点调用的片段在 set 中继续查找
+func optionalFields(set *template.Template, n parse.Node, keys, printed map[string]bool) map[string]bool {
+	if keys == nil {
+		keys = make(map[string]bool)
+	}
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, c := range n.Nodes {
+				optionalFields(set, c, keys, printed)
+			}
+		}
+	case *parse.IfNode:
+		pipeFields(n.Pipe, true, keys)
+		optionalFields(set, n.List, keys, printed)
+		optionalFields(set, n.ElseList, keys, printed)
+	case *parse.WithNode:
+		pipeFields(n.Pipe, true, keys)
+		optionalFields(set, n.ElseList, keys, printed)
+	case *parse.RangeNode:
+		pipeFields(n.Pipe, true, keys)
+		optionalFields(set, n.ElseList, keys, printed)
+	case *parse.ActionNode:
+		pipeFields(n.Pipe, false, keys)
+		// 无变量声明、以字段开头的动作直接输出该字段的值，如 {{.x}}、{{.x | printf "%s"}}
+		if len(n.Pipe.Decl) == 0 && len(n.Pipe.Cmds) > 0 {
+			switch a := n.Pipe.Cmds[0].Args[0].(type) {
+			case *parse.FieldNode:
+				printed[a.Ident[0]] = true
+			case *parse.VariableNode:
+				if a.Ident[0] == "$" && len(a.Ident) > 1 {
+					printed[a.Ident[1]] = true
+				}
+			}
+		}
+	case *parse.TemplateNode:
+		// 片段引用已在注册表构建时检查过无循环
+		if t := set.Lookup(n.Name); t != nil && t.Tree != nil && passesDot(n.Pipe) {
+			optionalFields(set, t.Root, keys, printed)
+		}
+	}
+	return keys
+}
+
+// pipeFields 收集管道中的可选字段，all为真时管道内全部顶层字段均可选
+func pipeFields(p *parse.PipeNode, all bool, keys map[string]bool) {
+	if p == nil {
+		return
+	}
+	// 管道中任一命令为可缺省函数时，前序命令的结果也会传入，整个管道可选
+	for _, cmd := range p.Cmds {
+		if id, ok := cmd.Args[0].(*parse.IdentifierNode); ok && optionalFuncs[id.Ident] {
+			all = true
+		}
+	}
+	for _, cmd := range p.Cmds {
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				if all {
+					keys[a.Ident[0]] = true
+				}
+			case *parse.VariableNode:
+				if all && a.Ident[0] == "$" && len(a.Ident) > 1 {
+					keys[a.Ident[1]] = true
+				}
+			case *parse.PipeNode:
+				pipeFields(a, all, keys)
+			}
+		}
+	}
+}
+
+// passesDot 判断片段调用是否原样传入点：{{template "名称" .}}
+func passesDot(p *parse.PipeNode) bool {
+	if p == nil || len(p.Cmds) != 1 || len(p.Cmds[0].Args) != 1 {
+		return false
+	}
+	_, ok := p.Cmds[0].Args[0].(*parse.DotNode)
+	return ok
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+	"text/template"
+	_ "unsafe" // go:linkname
+)
+
+// mssqlParseParams 是 go-mssqldb 以驱动名 mssql 打开时，Prepare 用于统计参数个数（NumInput）的解析器
+//
+//go:linkname mssqlParseParams github.com/denisenkom/go-mssqldb/internal/querytext.ParseParams
+func mssqlParseParams(query string) (string, int)
+
+// quotedRe 匹配SQL字符串字面量，服务端统计占位符时跳过其中的内容
+var quotedRe = regexp.MustCompile(`'(?:[^']|'')*'`)
+
+// serverParams 按mysql/postgres服务端的规则统计占位符个数：mysql为 ? 的个数，postgres为 $N 的最大序号
+func serverParams(driver, sqlstr string) int {
+	sqlstr = quotedRe.ReplaceAllString(sqlstr, "''")
+	if driver != "postgres" {
+		return len(regexp.MustCompile(`\?`).FindAllString(sqlstr, -1))
+	}
+	n := 0
+	for _, m := range regexp.MustCompile(`\$(\d+)`).FindAllStringSubmatch(sqlstr, -1) {
+		if i, _ := strconv.Atoi(m[1]); i > n {
+			n = i
+		}
+	}
+	return n
+}
+
+func TestRenderPlaceholders(t *testing.T) {
+	const tpl = `SELECT * FROM T WHERE A = '{{.a}}' AND B = {{quote .b}} AND ID = {{bind .id}} AND X IN {{in .ids}} {{andWhere "Y = ?" .y "Z = ?" .z}}`
+	param := Map{"a": "x?", "b": "it's?", "id": 1, "ids": []any{2, 3}, "y": 4, "z": ""}
+	tm := template.Must(template.New("t").Option("missingkey=error").Funcs(tplFuncs).Parse(tpl))
+	cases := []struct {
+		driver string
+		count  func(string) int
+		want   string
+	}{
+		{"mssql", func(s string) int { _, n := mssqlParseParams(s); return n },
+			`SELECT * FROM T WHERE A = 'x?' AND B = N'it''s?' AND ID = ? AND X IN (?, ?) AND Y = ?`},
+		{"mysql", func(s string) int { return serverParams("mysql", s) },
+			`SELECT * FROM T WHERE A = 'x?' AND B = 'it''s?' AND ID = ? AND X IN (?, ?) AND Y = ?`},
+		{"postgres", func(s string) int { return serverParams("postgres", s) },
+			`SELECT * FROM T WHERE A = 'x?' AND B = 'it''s?' AND ID = $1 AND X IN ($2, $3) AND Y = $4`},
+	}
+	for _, c := range cases {
+		sqlstr, args, err := render(tm, c.driver, param)
+		if err != nil {
+			t.Fatalf("%s: %v", c.driver, err)
+		}
+		if sqlstr != c.want {
+			t.Errorf("%s:\n得到 %s\n期望 %s", c.driver, sqlstr, c.want)
+		}
+		if n := c.count(sqlstr); n != len(args) {
+			t.Errorf("%s: 驱动统计到%d个参数，绑定了%d个", c.driver, n, len(args))
+		}
+	}
+}
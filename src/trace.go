@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"                                        // Web框架
+	"go.opentelemetry.io/otel"                                        // OpenTelemetry API
+	"go.opentelemetry.io/otel/attribute"                              // 链路属性
+	"go.opentelemetry.io/otel/codes"                                  // 链路状态
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp" // OTLP/HTTP导出
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"           // 标准输出导出
+	"go.opentelemetry.io/otel/propagation"                            // W3C traceparent
+	"go.opentelemetry.io/otel/sdk/resource"                           // 服务资源
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"                     // 链路SDK
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"                // 语义约定
+	"go.opentelemetry.io/otel/trace"                                  // 链路API
+)
+
+// Trace 定义OpenTelemetry链路追踪
+type Trace struct {
+	Exporter string  `json:"exporter"` // 导出方式：otlp/stdout，为空时不启用追踪
+	Endpoint string  `json:"endpoint"` // OTLP/HTTP收集器地址，如 127.0.0.1:4318，默认 localhost:4318
+	Insecure bool    `json:"insecure"` // 使用http而非https连接收集器
+	Sample   float64 `json:"sample"`   // 采样比例 0~1，默认1；请求携带traceparent时沿用上游的采样决定
+	Service  string  `json:"service"`  // 服务名，默认apigo
+}
+
+var (
+	tracer         = otel.Tracer("apigo")   // 未启用追踪时为空实现
+	tracerProvider *sdktrace.TracerProvider // 退出时刷新未导出的链路
+
+	// dbSystems 驱动对应的 db.system 属性
+	dbSystems = map[string]attribute.KeyValue{"mssql": semconv.DBSystemMSSQL, "mysql": semconv.DBSystemMySQL, "postgres": semconv.DBSystemPostgreSQL}
+)
+
+// initTrace 按配置创建导出器与采样器，并注册W3C traceparent传播
+func initTrace() error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t := cfg.Trace
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+	switch t.Exporter {
+	case "":
+		return nil
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(t.Endpoint)}
+		if t.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exp, err = otlptracehttp.New(context.Background(), opts...)
+	}
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(t.Sample))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(t.Service), semconv.ServiceVersion(version))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// Tracing 为每个请求创建服务端链路，沿用请求头中的traceparent；链路名称为 方法 路由
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracer.Start(ctx, c.Request.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethod(c.Request.Method), semconv.URLPath(c.Request.URL.Path)))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status, route := c.Writer.Status(), routeLabel(c)
+		span.SetName(c.Request.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPStatusCode(status), attribute.String("request.id", c.GetString("requestId")))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, c.GetString("errorCode"))
+		}
+	}
+}
+
+// endSpan 结束链路，出错时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// wechatErr 去除微信接口错误中的请求URL：返回的错误保留失败原因，链路中的错误只含接口名、操作与状态
+func wechatErr(api string, err error) (error, error) {
+	var ue *neturl.Error
+	if !errors.As(err, &ue) {
+		return err, err
+	}
+	status := "失败"
+	if ue.Timeout() {
+		status = "超时"
+	}
+	return fmt.Errorf("微信接口%s %s: %w", api, ue.Op, ue.Err), fmt.Errorf("微信接口%s %s %s", api, ue.Op, status)
+}
+
+// wechatGet 请求微信接口并读取响应体，记录客户端链路与调用耗时；网络错误或返回的errcode非0时计入失败次数
+// 请求URL含appsecret、js_code等参数，返回的错误与链路中的错误均不含URL
+func wechatGet(ctx context.Context, api, url string) (body []byte, err error) {
+	ctx, span := tracer.Start(ctx, "wechat "+api, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.HTTPMethod(http.MethodGet)))
+	start := time.Now()
+	defer func() {
+		wechatDuration.WithLabelValues(api).Observe(time.Since(start).Seconds())
+		var res struct {
+			ErrCode int `json:"errcode"`
+		}
+		if err == nil && json.Unmarshal(body, &res) == nil && res.ErrCode != 0 {
+			span.SetAttributes(attribute.Int("wechat.errcode", res.ErrCode))
+			span.SetStatus(codes.Error, fmt.Sprint("errcode ", res.ErrCode))
+			wechatErrors.WithLabelValues(api).Inc()
+		}
+		if err != nil {
+			wechatErrors.WithLabelValues(api).Inc()
+		}
+		var spanErr error
+		err, spanErr = wechatErr(api, err)
+		endSpan(span, spanErr)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin" // Web框架
+)
+
+type (
+	// Upload 定义文件上传的限制与存储后端
+	Upload struct {
+		Storage  string   `json:"storage"`  // 存储后端：local/s3，为空时不接收文件
+		Dir      string   `json:"dir"`      // local：文件保存目录
+		MaxSize  int      `json:"maxSize"`  // 单个文件最大大小（MB），默认10
+		MaxFiles int      `json:"maxFiles"` // 单个请求最多文件数，默认10
+		Types    []string `json:"types"`    // 允许的MIME类型，支持 image/* 通配，为空时不限制
+		Roots    []string `json:"roots"`    // file模式允许读取的目录，默认为dir
+		S3       S3       `json:"s3"`       // s3：S3兼容存储（如MinIO）的连接信息
+	}
+	// S3 定义S3兼容存储的连接信息，使用路径风格访问 endpoint/bucket/key
+	S3 struct {
+		Endpoint  string `json:"endpoint"`  // 服务地址，如 http://127.0.0.1:9000
+		Region    string `json:"region"`    // 区域，默认us-east-1
+		Bucket    string `json:"bucket"`    // 存储桶
+		AccessKey string `json:"accessKey"` // 访问密钥ID
+		SecretKey string `json:"secretKey"` // 访问密钥
+	}
+	// Storage 是文件存储后端，save按相对路径key保存并返回存储路径，remove按存储路径删除
+	Storage interface {
+		save(ctx context.Context, key string, r io.Reader, size int64, mimeType string) (string, error)
+		remove(ctx context.Context, key string) error
+	}
+	localStore struct{ dir string }
+	s3Store    struct{ conf S3 }
+)
+
+// store 当前使用的存储后端，未配置时为nil
+var store Storage
+
+// extRe 保存文件时只保留由字母数字组成的扩展名
+var extRe = regexp.MustCompile(`^\.[A-Za-z0-9]{1,10}$`)
+
+// initUpload 按配置创建存储后端
+func initUpload() {
+	switch u := cfg.Upload; u.Storage {
+	case "local":
+		if err := os.MkdirAll(u.Dir, 0o755); err != nil {
+			slog.Error("上传目录创建失败", "error", err)
+		}
+		store = localStore{u.Dir}
+	case "s3":
+		store = s3Store{u.S3}
+	}
+}
+
+// uploadLimit 请求体大小上限：全部文件的上限之和，另留1MB给普通字段
+func uploadLimit() int64 {
+	return int64(cfg.Upload.MaxSize*cfg.Upload.MaxFiles+1) << 20
+}
+
+// saveUploads 校验并保存请求中的文件，只接收路由上传列声明的字段，文件信息写入参数：
+// 单个文件为 {name, path, size, hash, mime}，同名字段多个文件为数组
+// 声明的字段只取自上传的文件，请求中同名的普通参数被忽略，防止伪造文件路径
+// 返回已保存文件的key，请求失败时用于清理
+func saveUploads(ctx context.Context, form *multipart.Form, fields []string, param Map) ([]string, error) {
+	allowed := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		allowed[f] = true
+		delete(param, f)
+	}
+	if form == nil || len(form.File) == 0 {
+		return nil, nil
+	}
+	u, count := cfg.Upload, 0
+	for field, fhs := range form.File {
+		if !allowed[field] {
+			return nil, errBadRequest.with(fmt.Sprintf("路由不接收上传文件[%s]", field), nil)
+		}
+		count += len(fhs)
+	}
+	if store == nil {
+		return nil, errBadRequest.with("未配置文件存储，不接收上传文件", nil)
+	}
+	if count > u.MaxFiles {
+		return nil, errTooLarge.with(fmt.Sprintf("上传文件数超过%d个", u.MaxFiles), nil)
+	}
+
+	var keys []string
+	for _, field := range sortedKeys(form.File) {
+		var infos []any
+		for _, fh := range form.File[field] {
+			info, err := saveFile(ctx, fh)
+			if err != nil {
+				removeUploads(keys)
+				return nil, err
+			}
+			keys = append(keys, info["path"].(string))
+			infos = append(infos, info)
+		}
+		if len(infos) == 1 {
+			param[field] = infos[0]
+		} else {
+			param[field] = infos
+		}
+	}
+	return keys, nil
+}
+
+// saveFile 校验大小与类型后保存单个文件，MIME类型按文件内容识别而非客户端声明
+func saveFile(ctx context.Context, fh *multipart.FileHeader) (Map, error) {
+	u := cfg.Upload
+	if fh.Size > int64(u.MaxSize)<<20 {
+		return nil, errTooLarge.with(fmt.Sprintf("文件[%s]超过%dMB", fh.Filename, u.MaxSize), nil)
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, errBadRequest.with("读取上传文件失败", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if !allowedType(mimeType, u.Types) {
+		return nil, errFileType.with(fmt.Sprintf("不允许上传%s类型的文件[%s]", mimeType, fh.Filename), nil)
+	}
+	h := sha256.New()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errInternal.with("", err)
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errBadRequest.with("读取上传文件失败", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errInternal.with("", err)
+	}
+
+	// 按日期分目录，文件名随机生成，避免覆盖与路径穿越
+	ext := strings.ToLower(filepath.Ext(fh.Filename))
+	if !extRe.MatchString(ext) {
+		ext = ""
+	}
+	key := path.Join(time.Now().Format("2006/01/02"), randomHex(16)+ext)
+	p, err := store.save(ctx, key, f, fh.Size, mimeType)
+	if err != nil {
+		return nil, errUpstream.with("文件保存失败", err)
+	}
+	return Map{"name": fh.Filename, "path": p, "size": fh.Size, "hash": hex.EncodeToString(h.Sum(nil)), "mime": mimeType}, nil
+}
+
+// removeUploads 删除已保存的文件，用于请求失败时清理
+func removeUploads(keys []string) {
+	for _, k := range keys {
+		CatchErr("REMOVE-UPLOAD", store.remove(context.Background(), k))
+	}
+}
+
+// allowedType 判断MIME类型是否在允许列表中，列表为空时全部允许
+func allowedType(mimeType string, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == mimeType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// randomHex 生成n字节的随机十六进制串
+func randomHex(n int) string {
+	b := make([]byte, n)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// save 将文件写入本地目录，返回的路径为 dir/key
+func (s localStore) save(_ context.Context, key string, r io.Reader, _ int64, _ string) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(p)
+		return "", err
+	}
+	return p, f.Close()
+}
+
+// remove 删除本地文件
+func (s localStore) remove(_ context.Context, p string) error {
+	return os.Remove(p)
+}
+
+// save 以PutObject上传到S3兼容存储，返回对象key
+func (s s3Store) save(ctx context.Context, key string, r io.Reader, size int64, mimeType string) (string, error) {
+	req, err := s.request(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", mimeType)
+	return key, s.do(req)
+}
+
+// remove 删除S3对象
+func (s s3Store) remove(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req)
+}
+
+// request 构造以AWS Signature V4签名的请求，请求体不参与签名（UNSIGNED-PAYLOAD）
+func (s s3Store) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.conf.Endpoint, "/")+"/"+s.conf.Bucket+"/"+key, body)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	headers := map[string]string{"host": req.URL.Host, "x-amz-content-sha256": "UNSIGNED-PAYLOAD", "x-amz-date": amzDate}
+	names := sortedKeys(headers)
+	var canon strings.Builder
+	for _, k := range names {
+		canon.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	creq := strings.Join([]string{method, req.URL.EscapedPath(), req.URL.RawQuery, canon.String(), signed, "UNSIGNED-PAYLOAD"}, "\n")
+	scope := day + "/" + s.conf.Region + "/s3/aws4_request"
+	sts := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(creq)
+
+	k := []byte("AWS4" + s.conf.SecretKey)
+	for _, v := range []string{day, s.conf.Region, "s3", "aws4_request"} {
+		k = hmacSHA256(k, v)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		s.conf.AccessKey, scope, signed, hmacSHA256(k, sts)))
+	return req, nil
+}
+
+// do 发送请求，非2xx响应视为失败
+func (s s3Store) do(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("S3 %s %s: %s %s", req.Method, req.URL.Path, resp.Status, msg)
+	}
+	return nil
+}
+
+// hmacSHA256 计算HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sha256Hex 计算SHA256并输出十六进制
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// serveFile 以首行的 data 二进制列或 path 文件路径列作为响应体，type/name 列指定内容类型与文件名
+// 支持 Range 分段请求，并以 ETag 支持缓存协商
+func serveFile(c *gin.Context, data []Map) {
+	if len(data) == 0 {
+		Fail(c, errNotFound.with("文件不存在", nil))
+		return
+	}
+	row := data[0]
+	text := func(k string) string {
+		if row[k] == nil {
+			return ""
+		}
+		return fmt.Sprint(Conv(row[k]))
+	}
+	name, ctype := text("name"), text("type")
+
+	var content io.ReadSeeker
+	var mod time.Time
+	switch v := row["data"]; {
+	case v != nil:
+		b, ok := v.([]byte)
+		if !ok {
+			b = []byte(fmt.Sprint(v))
+		}
+		sum := sha256.Sum256(b)
+		c.Header("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+		content = bytes.NewReader(b)
+	case row["path"] != nil:
+		p, ok := filePath(text("path"))
+		if !ok {
+			slog.Warn("文件不在允许读取的目录中", "path", text("path"))
+			Fail(c, errNotFound.with("文件不存在", nil))
+			return
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			Fail(c, errNotFound.with("文件不存在", nil))
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			Fail(c, errNotFound.with("文件不存在", nil))
+			return
+		}
+		if name == "" {
+			name = filepath.Base(p)
+		}
+		mod = fi.ModTime()
+		c.Header("ETag", fmt.Sprintf(`"%x-%x"`, mod.UnixNano(), fi.Size()))
+		content = f
+	default:
+		Fail(c, errTemplate.with("file模式的查询结果必须包含data或path列", nil))
+		return
+	}
+
+	if ctype != "" {
+		c.Header("Content-Type", ctype)
+	}
+	if name != "" {
+		c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+	}
+	http.ServeContent(c.Writer, c.Request, name, mod, content)
+}
+
+// filePath 解析文件路径，相对路径基于第一个允许的目录，且必须位于允许的目录之内
+func filePath(p string) (string, bool) {
+	roots := cfg.Upload.Roots
+	if len(roots) == 0 {
+		return "", false
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(roots[0], p)
+	}
+	p, _ = filepath.Abs(p)
+	for _, root := range roots {
+		root, _ = filepath.Abs(root)
+		if rel, err := filepath.Rel(root, p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return p, true
+		}
+	}
+	return "", false
+}
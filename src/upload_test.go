@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 是S3兼容存储的本地替身：按AWS Signature V4校验签名，对象保存在内存中
+type fakeS3 struct {
+	secret  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verify(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		if int64(len(b)) != r.ContentLength {
+			http.Error(w, "长度不符", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = b
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// verify 按收到的请求重新计算签名，与Authorization头中的签名比较
+func (f *fakeS3) verify(r *http.Request) error {
+	var cred, signed, sig string
+	for _, part := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), ", ") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "Credential":
+			cred = v
+		case "SignedHeaders":
+			signed = v
+		case "Signature":
+			sig = v
+		}
+	}
+	scope := strings.SplitN(cred, "/", 2)
+	if len(scope) != 2 {
+		return fmt.Errorf("缺少Credential")
+	}
+	names := strings.Split(signed, ";")
+	if !sort.StringsAreSorted(names) {
+		return fmt.Errorf("SignedHeaders未排序")
+	}
+	var canon strings.Builder
+	for _, n := range names {
+		v := r.Header.Get(n)
+		if n == "host" {
+			v = r.Host
+		}
+		canon.WriteString(n + ":" + v + "\n")
+	}
+	creq := strings.Join([]string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery, canon.String(), signed, r.Header.Get("X-Amz-Content-Sha256")}, "\n")
+	sts := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope[1] + "\n" + sha256Hex(creq)
+	k := []byte("AWS4" + f.secret)
+	for _, v := range strings.Split(scope[1], "/") {
+		k = hmacSHA256(k, v)
+	}
+	if want := fmt.Sprintf("%x", hmacSHA256(k, sts)); sig != want {
+		return fmt.Errorf("签名不符")
+	}
+	return nil
+}
+
+// withS3 启动S3替身并将其设为存储后端
+func withS3(t *testing.T, secret string) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{secret: "right", objects: map[string][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	old, oldCfg := store, cfg.Upload
+	t.Cleanup(func() { store, cfg.Upload = old, oldCfg })
+	cfg.Upload = Upload{Storage: "s3", MaxSize: 1, MaxFiles: 2, Types: []string{"image/*"},
+		S3: S3{Endpoint: srv.URL, Region: "us-east-1", Bucket: "apigo", AccessKey: "ak", SecretKey: secret}}
+	store = s3Store{cfg.Upload.S3}
+	return f
+}
+
+// multipartForm 构造包含给定文件的表单，值为文件内容
+func multipartForm(t *testing.T, files map[string][]string) *multipart.Form {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for field, contents := range files {
+		for i, c := range contents {
+			fw, _ := w.CreateFormFile(field, fmt.Sprintf("%s%d.png", field, i))
+			fw.Write([]byte(c))
+		}
+	}
+	w.Close()
+	form, err := multipart.NewReader(&buf, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
+
+// png 返回可被识别为image/png的内容
+func png(size int) string {
+	return "\x89PNG\r\n\x1a\n" + strings.Repeat("x", size)
+}
+
+func TestS3RequestSigned(t *testing.T) {
+	f := withS3(t, "right")
+	ctx := context.Background()
+	key, err := store.save(ctx, "2024/01/02/a.png", strings.NewReader("data"), 4, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(f.objects["/apigo/"+key]) != "data" {
+		t.Fatalf("对象未保存: %v", f.objects)
+	}
+	if err := store.remove(ctx, key); err != nil || len(f.objects) != 0 {
+		t.Fatalf("删除失败: %v %v", err, f.objects)
+	}
+
+	withS3(t, "wrong")
+	if _, err := store.save(ctx, "a.png", strings.NewReader("data"), 4, "image/png"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("错误的密钥应被拒绝: %v", err)
+	}
+}
+
+func TestSaveFileLimits(t *testing.T) {
+	f := withS3(t, "right")
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		content string
+		code    string
+	}{
+		{"ok", png(10), ""},
+		{"too large", png(1 << 20), "TOO_LARGE"},
+		{"type", "plain text", "FILE_TYPE"},
+	}
+	for _, c := range cases {
+		fh := multipartForm(t, map[string][]string{"photo": {c.content}}).File["photo"][0]
+		info, err := saveFile(ctx, fh)
+		if c.code == "" {
+			if err != nil || info["mime"] != "image/png" || f.objects["/apigo/"+info["path"].(string)] == nil {
+				t.Fatalf("%s: %v %v", c.name, info, err)
+			}
+			continue
+		}
+		if ae, ok := err.(*ApiError); !ok || ae.Code != c.code {
+			t.Fatalf("%s: 期望%s，得到%v", c.name, c.code, err)
+		}
+	}
+}
+
+func TestSaveUploadsFields(t *testing.T) {
+	f := withS3(t, "right")
+	ctx := context.Background()
+
+	// 未声明的字段拒绝，不保存任何文件
+	param := Map{}
+	if _, err := saveUploads(ctx, multipartForm(t, map[string][]string{"photo": {png(1)}}), nil, param); err == nil {
+		t.Fatal("未声明上传字段的路由应拒绝文件")
+	}
+	// 超过文件数上限
+	if _, err := saveUploads(ctx, multipartForm(t, map[string][]string{"photo": {png(1), png(1), png(1)}}), []string{"photo"}, param); err == nil {
+		t.Fatal("文件数超过上限应拒绝")
+	}
+	if len(f.objects) != 0 {
+		t.Fatalf("被拒绝的请求不应保存文件: %v", f.objects)
+	}
+	// 请求中伪造的同名参数被忽略
+	param = Map{"photo": Map{"path": "/etc/passwd"}, "other": 1}
+	keys, err := saveUploads(ctx, nil, []string{"photo"}, param)
+	if err != nil || keys != nil || param["photo"] != nil || param["other"] != 1 {
+		t.Fatalf("伪造的上传参数未被忽略: %v %v", param, err)
+	}
+	keys, err = saveUploads(ctx, multipartForm(t, map[string][]string{"photo": {png(1), png(2)}}), []string{"photo"}, param)
+	if err != nil || len(keys) != 2 || len(param["photo"].([]any)) != 2 {
+		t.Fatalf("保存失败: %v %v %v", keys, param, err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin" // Web框架
+	"github.com/jmoiron/sqlx"  // 增强的数据库操作包
+)
+
+// pinnedRe 匹配固定版本的路径段，如 /api/v2/orders 中的 v2，版本路径注册为 cfg.Api 中 :a 后追加 /:pinned
+var pinnedRe = regexp.MustCompile(`^v(\d+)$`)
+
+// routeName 从请求路径读取路由名与固定版本号，如 /api/v2/orders 返回 orders 与 2，非固定版本请求的版本号为0
+// 固定版本路径的版本段无效时 ok 为false
+func routeName(c *gin.Context) (name string, ver int, ok bool) {
+	name = c.Param("a")
+	p := c.Param("pinned")
+	if p == "" {
+		return name, 0, true
+	}
+	m := pinnedRe.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, false
+	}
+	ver, _ = strconv.Atoi(m[1])
+	return p, ver, true
+}
+
+// versions 返回版本表的SQL生成器，版本表列为 路由/方法/版本/定义/作者/时间/差异
+func (q tableQuery) versions() tableQuery {
+	return tableQuery{driver: q.driver, table: cfg.Admin.Versions}
+}
+
+// recordVersion 记录一次路由修改：定义为修改后的整行JSON（删除时为删除前的定义），差异为逐列对比
+// 路由尚无版本且存在修改前的定义时，先将其记录为初始版本
+func (q tableQuery) recordVersion(ctx context.Context, tx sqlx.ExtContext, name, method string, old, cur Map, author, note string) error {
+	v := q.versions()
+	var last int
+	err := sqlx.GetContext(ctx, tx, &last, tx.Rebind("SELECT COALESCE(MAX("+v.ident("版本")+"), 0) FROM "+v.table+
+		" WHERE "+v.ident("路由")+" = ? AND "+v.ident("方法")+" = ?"), name, method)
+	if err != nil {
+		return err
+	}
+	add := func(def Map, diff string) error {
+		last++
+		b, _ := json.Marshal(def)
+		sqlstr, args := v.insert(Map{"路由": name, "方法": method, "版本": last, "定义": string(b), "作者": author, "时间": time.Now(), "差异": diff})
+		_, err := v.exec(ctx, tx, sqlstr, args...)
+		return err
+	}
+	if last == 0 && old != nil && cur != nil {
+		if err := add(old, "初始版本"); err != nil {
+			return err
+		}
+	}
+	if cur == nil {
+		return add(old, note)
+	}
+	return add(cur, strings.TrimSpace(note+"\n"+diffRoutes(old, cur)))
+}
+
+// AdminVersions 列出路由的版本历史，新版本在前
+func AdminVersions(c *gin.Context) {
+	db, q, err := adminDB()
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	v := q.versions()
+	sqlstr := "SELECT * FROM " + v.table + " WHERE " + v.ident("路由") + " = ? AND " + v.ident("方法") + " = ? ORDER BY " + v.ident("版本") + " DESC"
+	res, err := queryRows(c.Request.Context(), db, true, db.Rebind(sqlstr), c.Param("name"), strings.ToUpper(c.Param("method")))
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, Map{"data": res.Rows, "status": 0})
+}
+
+// AdminRollback 将路由恢复为指定版本的定义，路由已删除时重新创建，回滚本身也记录为新版本
+func AdminRollback(c *gin.Context) {
+	ctx := c.Request.Context()
+	name, method := c.Param("name"), strings.ToUpper(c.Param("method"))
+	ver, _ := strconv.Atoi(c.Param("version"))
+	db, q, err := adminDB()
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	def, err := q.version(ctx, db, name, method, ver)
+	if errors.Is(err, sql.ErrNoRows) {
+		Fail(c, errNotFound.with("版本不存在", nil))
+		return
+	}
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	if _, ae := validateRoute(def, method+" "+name); ae != nil {
+		Fail(c, ae)
+		return
+	}
+	cols, err := q.columns(ctx, db)
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	// 只恢复路由表现有的列，主键保持不变
+	body := Map{}
+	for k, v := range def {
+		if cols[k] && k != cfg.Admin.ID {
+			body[k] = v
+		}
+	}
+	old, err := q.get(ctx, db, name, method)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		Fail(c, dbErr(err))
+		return
+	}
+	row, err := q.commit(c, db, name, method, old, fmt.Sprintf("回滚到版本%d", ver), func(tx *sqlx.Tx) (int64, error) {
+		if old == nil {
+			if id := cfg.Admin.ID; id != "" && cols[id] {
+				body[id] = randomHex(16)
+			}
+			sqlstr, args := q.insert(body)
+			return q.exec(ctx, tx, sqlstr, args...)
+		}
+		sqlstr, args := q.update(body)
+		return q.exec(ctx, tx, sqlstr, append(args, name, method)...)
+	})
+	if err != nil {
+		Fail(c, dbErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, Map{"data": row, "status": 0})
+}
+
+// version 读取指定版本的路由定义
+func (q tableQuery) version(ctx context.Context, db sqlx.ExtContext, name, method string, ver int) (Map, error) {
+	v := q.versions()
+	var def string
+	err := sqlx.GetContext(ctx, db, &def, db.Rebind("SELECT "+v.ident("定义")+" FROM "+v.table+
+		" WHERE "+v.ident("路由")+" = ? AND "+v.ident("方法")+" = ? AND "+v.ident("版本")+" = ?"), name, method, ver)
+	if err != nil {
+		return nil, err
+	}
+	var row Map
+	return row, json.Unmarshal([]byte(def), &row)
+}
+
+// getVersion 读取固定版本的路由定义，供 /api/v2/:a 形式的请求使用，当前路由的校验见 lookup
+func getVersion(ctx context.Context, action, method string, ver int) (*Route, error) {
+	if cfg.Admin.Versions == "" {
+		return nil, sql.ErrNoRows
+	}
+	db, q, err := adminDB()
+	if err != nil {
+		return nil, err
+	}
+	row, err := q.version(ctx, db, action, method, ver)
+	if err != nil {
+		return nil, err
+	}
+	return parseRoute(func(name string, _ int) any {
+		if v, ok := row[name]; ok && v != nil {
+			return v
+		}
+		return ""
+	})
+}
+
+// diffRoutes 逐列对比两个路由定义，模板按行输出差异
+func diffRoutes(old, cur Map) string {
+	keys := map[string]bool{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range cur {
+		keys[k] = true
+	}
+	var out []string
+	for _, k := range sortedKeys(keys) {
+		a, b := rowText(old, k), rowText(cur, k)
+		switch {
+		case a == b:
+		case k == "模板":
+			out = append(out, "模板:")
+			out = append(out, diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))...)
+		default:
+			out = append(out, fmt.Sprintf("%s: %s → %s", k, a, b))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// diffLines 基于最长公共子序列的逐行差异，删除行以 - 开头，新增行以 + 开头
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = lcs[i+1][j]
+				if lcs[i][j+1] > lcs[i][j] {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	return out
+}